@@ -0,0 +1,38 @@
+// Command hashtoken prints the digests of the tokens read from standard input.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	algorithm := os.Getenv("RATE_LIMIT_TOKEN_HASH")
+	if algorithm == "" || algorithm == middleware.TokenHashNone {
+		algorithm = middleware.TokenHashSHA256
+	}
+	hasher, err := middleware.ParseTokenHasher(algorithm, []byte(os.Getenv("RATE_LIMIT_TOKEN_SECRET")))
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_TOKEN_HASH: %v", err)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" {
+			continue
+		}
+		fmt.Println(hasher(token))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read tokens: %v", err)
+	}
+}
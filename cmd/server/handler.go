@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/config"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
+)
+
+type store interface {
+	limiter.Store
+	limiter.ConcurrencyStore
+}
+
+// newHandler builds the application handler wrapped in the limiters of cfg.
+func newHandler(cfg *config.Config, store store, redisTokens *limiter.RedisTokenProvider) (http.Handler, error) {
+	plans := limiter.StaticTokens(cfg.Plans)
+	var tokens limiter.TokenProvider = limiter.ResolvePlans(limiter.StaticTokens(cfg.TokenConfigs), plans)
+	if redisTokens != nil {
+		tokens = limiter.TokenProviders{tokens, limiter.ResolvePlans(redisTokens, plans)}
+	}
+
+	// The global limit is checked by every limiter along with the client limits.
+	var globalLimiter *limiter.GlobalLimiter
+	if cfg.GlobalLimit > 0 {
+		globalLimiter = limiter.NewGlobalLimiter(
+			cfg.GlobalLimit,
+			nil,
+			limiter.WithGlobalTokenProvider(tokens),
+			limiter.WithGlobalPlanProvider(plans),
+			limiter.WithGlobalWindow(cfg.GlobalWindow),
+			limiter.WithGlobalReserved(cfg.GlobalReserved),
+		)
+	}
+
+	limiterOptions := []limiter.Option{
+		limiter.WithTokenProvider(tokens),
+		limiter.WithPlanProvider(plans),
+		limiter.WithIPAlgorithm(cfg.IPAlgorithm),
+		limiter.WithIPWindow(cfg.IPWindow),
+		limiter.WithIPBurst(cfg.IPBurst),
+		limiter.WithIPMaxWait(cfg.IPMaxWait),
+		limiter.WithIPPrefix(cfg.IPPrefix),
+		limiter.WithGlobalLimiter(globalLimiter),
+	}
+	concurrencyOptions := []limiter.ConcurrencyOption{
+		limiter.WithConcurrencyIPPrefix(cfg.IPPrefix),
+		limiter.WithConcurrencyTokenProvider(tokens),
+		limiter.WithConcurrencyPlanProvider(plans),
+	}
+	// Every JWT client is limited by its own claim.
+	if _, ok := cfg.KeyExtractor.(*middleware.JWTKey); ok {
+		limiterOptions = append(limiterOptions, limiter.WithPerTokenLimit())
+		concurrencyOptions = append(concurrencyOptions, limiter.WithConcurrencyPerTokenLimit())
+	}
+	rateLimiter := limiter.NewRateLimiter(store, cfg.IPLimit, cfg.IPBlockDuration, nil, limiterOptions...)
+
+	routes := make([]middleware.Route, len(cfg.Routes))
+	for i, rule := range cfg.Routes {
+		ruleOptions := []limiter.Option{
+			limiter.WithRuleID(rule.ID),
+			limiter.WithIPAlgorithm(rule.Algorithm),
+			limiter.WithIPWindow(rule.Window),
+			limiter.WithIPBurst(rule.Burst),
+			limiter.WithIPPrefix(rule.IPPrefix),
+			limiter.WithPerTokenLimit(),
+			limiter.WithGlobalLimiter(globalLimiter),
+		}
+		// Only JWT keys are verified, so other route keys get the rule's IP limit too.
+		if _, ok := rule.Key.(*middleware.JWTKey); !ok {
+			ruleOptions = append(ruleOptions, limiter.WithTokenIPLimit())
+		}
+		routes[i] = middleware.Route{
+			ID:      rule.ID,
+			Pattern: rule.Pattern,
+			Key:     rule.Key,
+			Limiter: limiter.NewRateLimiter(store, rule.Limit, rule.BlockDuration, nil, ruleOptions...),
+		}
+	}
+
+	routeTable, err := middleware.NewRouteTable(routes, cfg.RoutePrecedence)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route rules: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK\n"))
+	})
+
+	concurrencyLimiter := limiter.NewConcurrencyLimiter(store, cfg.IPConcurrency, nil, cfg.LeaseTTL, concurrencyOptions...)
+
+	clientIP := middleware.WithClientIPResolver(
+		middleware.NewClientIPResolver(cfg.ClientIPHeader, cfg.TrustedProxies, cfg.TrustedProxyHops),
+	)
+
+	keyExtractor := middleware.WithKeyExtractor(cfg.KeyExtractor)
+	tokenHasher := middleware.WithTokenHasher(cfg.TokenHasher)
+
+	routeRules := middleware.WithRoutes(routeTable)
+
+	rateLimiterOptions := []middleware.Option{
+		middleware.WithHeaderStyle(cfg.HeaderStyle),
+		routeRules,
+		clientIP,
+		keyExtractor,
+		tokenHasher,
+		middleware.WithGlobalStatus(cfg.GlobalStatus),
+	}
+
+	return middleware.RateLimiter(rateLimiter, rateLimiterOptions...)(
+		middleware.Concurrency(concurrencyLimiter, routeRules, clientIP, keyExtractor, tokenHasher)(mux),
+	), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/config"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewHandler_PlanClaimNamesOnlyPlans(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	os.Setenv("STORE_BACKEND", "memory")
+	os.Setenv("RATE_LIMIT_IP", "10")
+	os.Setenv("RATE_LIMIT_TOKENS", "pro:100:60")
+	os.Setenv("RATE_LIMIT_PLANS", "gold:50:60")
+	os.Setenv("RATE_LIMIT_KEY", "jwt")
+	os.Setenv("JWT_SECRET", "secret")
+	os.Setenv("JWT_LIMIT_CLAIM", "plan")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler, err := newHandler(cfg, limiter.NewMemoryStore(0, 0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		plan      string
+		wantLimit string
+	}{
+		{"gold", "50"},
+		// A configured token is not a plan, so the client gets the IP
+		// limit's settings.
+		{"pro", "10"},
+	}
+	for _, tt := range tests {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-" + tt.plan, "plan": tt.plan}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("X-RateLimit-Limit"); rec.Code != http.StatusOK || got != tt.wantLimit {
+			t.Errorf("plan %s: expected status 200 with limit %s, got %d with limit %q", tt.plan, tt.wantLimit, rec.Code, got)
+		}
+	}
+}
+
+func TestNewHandler_RouteKeyRotation(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	os.Setenv("STORE_BACKEND", "memory")
+	os.Setenv("RATE_LIMIT_ROUTES", "id=api;pattern=GET /api/;limit=2;window=60;key=header:X-Client")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler, err := newHandler(cfg, limiter.NewMemoryStore(0, 0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+		req.Header.Set("X-Client", fmt.Sprintf("client-%d", i))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected rotating keys to stay within the rule's limit of 2, got %d allowed", allowed)
+	}
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
 	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/config"
 	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
-	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -16,31 +16,43 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPassword,
-		DB:       0,
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	store := limiter.NewRedisStore(redisClient)
+	var redisClient *redis.Client
+	if cfg.StoreBackend == config.StoreBackendRedis || cfg.RedisTokens {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       0,
+		})
+	}
+
+	var store store
 
-	rateLimiter := limiter.NewRateLimiter(
-		store,
-		cfg.IPLimit,
-		cfg.IPBlockDuration,
-		cfg.TokenConfigs,
-	)
+	switch cfg.StoreBackend {
+	case config.StoreBackendMemory:
+		memoryStore := limiter.NewMemoryStore(cfg.MemoryMaxEntries, 0)
+		defer memoryStore.Close()
+		store = memoryStore
+	default:
+		store = limiter.NewRedisStore(redisClient)
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK\n"))
-	})
+	var redisTokens *limiter.RedisTokenProvider
+	if cfg.RedisTokens {
+		redisTokens = limiter.NewRedisTokenProvider(redisClient, limiter.WithTokenCacheTTL(cfg.TokenCacheTTL))
+		go redisTokens.Listen(ctx)
+	}
 
-	handler := middleware.RateLimiter(rateLimiter)(mux)
+	server, err := newServer(cfg, store, redisTokens)
+	if err != nil {
+		log.Fatalf("Failed to build handler: %v", err)
+	}
+	server.watchReloads(ctx)
 
 	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", handler); err != nil {
+	if err := http.ListenAndServe(":8080", server); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/config"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+// server serves requests through the handler of the current config.
+type server struct {
+	store       store
+	redisTokens *limiter.RedisTokenProvider
+	handler     atomic.Pointer[http.Handler]
+
+	mu        sync.Mutex
+	cfg       *config.Config
+	watchCtx  context.Context
+	watched   string
+	stopWatch context.CancelFunc
+}
+
+func newServer(cfg *config.Config, store store, redisTokens *limiter.RedisTokenProvider) (*server, error) {
+	handler, err := newHandler(cfg, store, redisTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{store: store, redisTokens: redisTokens, cfg: cfg}
+	s.handler.Store(&handler)
+	return s, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
+
+// watchReloads reloads the config on SIGHUP and whenever the policy file changes.
+func (s *server) watchReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Println("Received SIGHUP, reloading configuration")
+				s.reload()
+			}
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchCtx = ctx
+	s.watchFile(s.cfg.ConfigFile)
+}
+
+// watchFile watches the policy file at path instead of the current one; s.mu must be held.
+func (s *server) watchFile(path string) {
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+	s.watched = path
+	if path == "" || s.watchCtx == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.watchCtx)
+	err := config.Watch(ctx, path, func() {
+		log.Printf("%s changed, reloading configuration", path)
+		s.reload()
+	}, func(err error) {
+		log.Printf("Policy file watcher error: %v", err)
+	})
+	if err != nil {
+		cancel()
+		log.Printf("Policy file changes will not be reloaded: %v", err)
+		return
+	}
+	s.stopWatch = cancel
+}
+
+// reload swaps in a handler built from the reloaded config, keeping the current one if invalid.
+func (s *server) reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Rejected configuration reload, keeping the current one: %v", err)
+		return
+	}
+
+	if cfg.StoreBackend != s.cfg.StoreBackend || cfg.RedisAddr != s.cfg.RedisAddr ||
+		cfg.RedisPassword != s.cfg.RedisPassword || cfg.MemoryMaxEntries != s.cfg.MemoryMaxEntries ||
+		cfg.RedisTokens != s.cfg.RedisTokens || cfg.TokenCacheTTL != s.cfg.TokenCacheTTL {
+		log.Println("Store settings changed; they take effect on restart")
+	}
+
+	handler, err := newHandler(cfg, s.store, s.redisTokens)
+	if err != nil {
+		log.Printf("Rejected configuration reload, keeping the current one: %v", err)
+		return
+	}
+	s.handler.Store(&handler)
+
+	changes := config.Diff(s.cfg, cfg)
+	s.cfg = cfg
+	if cfg.ConfigFile != s.watched {
+		s.watchFile(cfg.ConfigFile)
+	}
+	if len(changes) == 0 {
+		log.Println("Configuration reloaded without changes")
+		return
+	}
+	for _, change := range changes {
+		log.Printf("Configuration changed: %s", change)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/config"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := newServer(cfg, limiter.NewMemoryStore(0, 0), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func writeDotEnv(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(".env", []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write .env: %v", err)
+	}
+}
+
+// limitOf returns the IP limit the server applies to a request.
+func limitOf(t *testing.T, s *server) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	return rec.Header().Get("X-RateLimit-Limit")
+}
+
+func TestServer_ReloadSwapsHandler(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	writeDotEnv(t, "STORE_BACKEND=memory\nRATE_LIMIT_IP=10\n")
+
+	s := newTestServer(t)
+	old := s.handler.Load()
+	if limit := limitOf(t, s); limit != "10" {
+		t.Fatalf("expected limit 10, got %q", limit)
+	}
+
+	writeDotEnv(t, "STORE_BACKEND=memory\nRATE_LIMIT_IP=20\n")
+	s.reload()
+
+	if s.handler.Load() == old {
+		t.Error("expected the handler to be swapped")
+	}
+	if s.cfg.IPLimit != 20 {
+		t.Errorf("expected the reloaded config, got IP limit %d", s.cfg.IPLimit)
+	}
+	if limit := limitOf(t, s); limit != "20" {
+		t.Errorf("expected limit 20 after the reload, got %q", limit)
+	}
+}
+
+func TestServer_ReloadKeepsHandlerOnInvalidConfig(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	writeDotEnv(t, "STORE_BACKEND=memory\nRATE_LIMIT_IP=10\n")
+
+	s := newTestServer(t)
+	old, oldCfg := s.handler.Load(), s.cfg
+
+	for _, content := range []string{
+		"STORE_BACKEND=memory\nRATE_LIMIT_IP=ten\n",
+		"STORE_BACKEND=memory\nRATE_LIMIT_IP=20\nRATE_LIMIT_ROUTES=id=a;pattern=GET /{;limit=1\n",
+	} {
+		writeDotEnv(t, content)
+		s.reload()
+
+		if s.handler.Load() != old || s.cfg != oldCfg {
+			t.Errorf("expected %q to be rejected and the current handler kept", content)
+		}
+		if limit := limitOf(t, s); limit != "10" {
+			t.Errorf("expected limit 10 to still apply, got %q", limit)
+		}
+	}
+}
+
+func TestServer_ReloadMovesPolicyFileWatcher(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	writePolicy := func(name string, limit int) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("ip:\n  limit: %d\n", limit)), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	writePolicy("a.yaml", 100)
+	writePolicy("b.yaml", 200)
+	writeDotEnv(t, "STORE_BACKEND=memory\nRATE_LIMIT_CONFIG_FILE=a.yaml\n")
+
+	s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.watchReloads(ctx)
+
+	writeDotEnv(t, "STORE_BACKEND=memory\nRATE_LIMIT_CONFIG_FILE=b.yaml\n")
+	s.reload()
+	if limit := limitOf(t, s); limit != "200" {
+		t.Fatalf("expected limit 200 from b.yaml, got %q", limit)
+	}
+
+	writePolicy("b.yaml", 300)
+	deadline := time.Now().Add(5 * time.Second)
+	for limitOf(t, s) != "300" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a change to b.yaml to be reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
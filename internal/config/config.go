@@ -2,88 +2,782 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
 	"github.com/joho/godotenv"
 )
 
+const (
+	StoreBackendRedis  = "redis"
+	StoreBackendMemory = "memory"
+)
+
+// RouteRule is a limit applied to the requests matching an http.ServeMux pattern.
+type RouteRule struct {
+	ID            string
+	Pattern       string
+	Limit         int
+	BlockDuration time.Duration
+	Algorithm     limiter.Algorithm
+	Window        time.Duration
+	Burst         int
+	// IPPrefix aggregates the addresses of clients limited by IP.
+	IPPrefix limiter.IPPrefix
+	// Key identifies the client; nil limits by IP.
+	Key middleware.KeyExtractor
+
+	keySpec string
+}
+
 type Config struct {
-	RedisAddr       string
-	RedisPassword   string
-	IPLimit         int
-	IPBlockDuration time.Duration
-	TokenConfigs    map[string]limiter.TokenConfig
+	ConfigFile       string
+	StoreBackend     string
+	RedisAddr        string
+	RedisPassword    string
+	MemoryMaxEntries int
+	IPLimit          int
+	IPBlockDuration  time.Duration
+	IPAlgorithm      limiter.Algorithm
+	IPWindow         time.Duration
+	IPBurst          int
+	IPMaxWait        time.Duration
+	IPPrefix         limiter.IPPrefix
+	IPConcurrency    int
+	LeaseTTL         time.Duration
+	TokenConfigs     map[string]limiter.TokenConfig
+	Plans            map[string]limiter.TokenConfig
+	TokenHasher      middleware.TokenHasher
+	RedisTokens      bool
+	TokenCacheTTL    time.Duration
+	GlobalLimit      int
+	GlobalWindow     time.Duration
+	GlobalReserved   int
+	GlobalStatus     int
+	HeaderStyle      middleware.HeaderStyle
+	KeyExtractor     middleware.KeyExtractor
+	Routes           []RouteRule
+	RoutePrecedence  middleware.Precedence
+	TrustedProxies   []netip.Prefix
+	TrustedProxyHops int
+	ClientIPHeader   middleware.IPHeader
 }
 
+// dotenv holds the variables of the .env file, kept apart from the process environment.
+var dotenv map[string]string
+
+// Load reads the config from the environment and then the .env file.
 func Load() (*Config, error) {
-	_ = godotenv.Load()
+	dotenv, _ = godotenv.Read()
+
+	configFile := getEnv("RATE_LIMIT_CONFIG_FILE", "")
+	policy, err := loadPolicyFile(configFile)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &Config{
+		ConfigFile:    configFile,
 		RedisAddr:     getEnv("REDIS_ADDR", "redis:6379"),
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
 	}
 
-	ipLimit, err := strconv.Atoi(getEnv("RATE_LIMIT_IP", "10"))
+	switch backend := getEnv("STORE_BACKEND", StoreBackendRedis); backend {
+	case StoreBackendRedis, StoreBackendMemory:
+		cfg.StoreBackend = backend
+	default:
+		return nil, fmt.Errorf("invalid STORE_BACKEND: %q (expected %s or %s)", backend, StoreBackendRedis, StoreBackendMemory)
+	}
+
+	memoryMaxEntries, err := strconv.Atoi(getEnv("MEMORY_STORE_MAX_ENTRIES", "100000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid MEMORY_STORE_MAX_ENTRIES: %w", err)
+	}
+	if memoryMaxEntries < 0 {
+		return nil, fmt.Errorf("invalid MEMORY_STORE_MAX_ENTRIES: must not be negative")
+	}
+	cfg.MemoryMaxEntries = memoryMaxEntries
+
+	ipLimit := policy.setting("RATE_LIMIT_IP", "10")
+	cfg.IPLimit, err = strconv.Atoi(ipLimit.value)
 	if err != nil {
-		return nil, fmt.Errorf("invalid RATE_LIMIT_IP: %w", err)
+		return nil, ipLimit.invalid(err)
 	}
-	cfg.IPLimit = ipLimit
 
-	ipBlockSec, err := strconv.Atoi(getEnv("RATE_LIMIT_IP_BLOCK_DURATION", "300"))
+	ipBlockDuration := policy.setting("RATE_LIMIT_IP_BLOCK_DURATION", "300")
+	cfg.IPBlockDuration, err = parseDuration(ipBlockDuration.value)
 	if err != nil {
-		return nil, fmt.Errorf("invalid RATE_LIMIT_IP_BLOCK_DURATION: %w", err)
+		return nil, ipBlockDuration.invalid(err)
 	}
-	cfg.IPBlockDuration = time.Duration(ipBlockSec) * time.Second
 
-	tokenConfigs, err := parseTokenConfigs(getEnv("RATE_LIMIT_TOKENS", ""))
+	ipAlgorithm := policy.setting("RATE_LIMIT_IP_ALGORITHM", string(limiter.FixedWindow))
+	cfg.IPAlgorithm, err = limiter.ParseAlgorithm(ipAlgorithm.value)
+	if err != nil {
+		return nil, ipAlgorithm.invalid(err)
+	}
+
+	ipWindow := policy.setting("RATE_LIMIT_IP_WINDOW", "1")
+	cfg.IPWindow, err = parseWindow(ipWindow.value)
+	if err != nil {
+		return nil, ipWindow.invalid(err)
+	}
+
+	ipBurst := policy.setting("RATE_LIMIT_IP_BURST", "0")
+	cfg.IPBurst, err = strconv.Atoi(ipBurst.value)
+	if err != nil {
+		return nil, ipBurst.invalid(err)
+	}
+
+	ipMaxWait := policy.setting("RATE_LIMIT_IP_MAX_WAIT", "0s")
+	cfg.IPMaxWait, err = time.ParseDuration(ipMaxWait.value)
+	if err != nil {
+		return nil, ipMaxWait.invalid(err)
+	}
+
+	cfg.IPPrefix, err = parseIPPrefix(policy.setting("RATE_LIMIT_IP_PREFIX_V4", "32"), policy.setting("RATE_LIMIT_IP_PREFIX_V6", "64"))
+	if err != nil {
+		return nil, err
+	}
+
+	ipConcurrency := policy.setting("RATE_LIMIT_IP_CONCURRENCY", "0")
+	cfg.IPConcurrency, err = strconv.Atoi(ipConcurrency.value)
+	if err != nil {
+		return nil, ipConcurrency.invalid(err)
+	}
+
+	leaseTTL, err := time.ParseDuration(getEnv("RATE_LIMIT_CONCURRENCY_LEASE", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CONCURRENCY_LEASE: %w", err)
+	}
+	if leaseTTL <= 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CONCURRENCY_LEASE: must be positive")
+	}
+	cfg.LeaseTTL = leaseTTL
+
+	tokenHasher, err := middleware.ParseTokenHasher(getEnv("RATE_LIMIT_TOKEN_HASH", middleware.TokenHashNone), []byte(getEnv("RATE_LIMIT_TOKEN_SECRET", "")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_HASH: %w", err)
+	}
+	cfg.TokenHasher = tokenHasher
+
+	tokens := getEnv("RATE_LIMIT_TOKENS", "")
+	tokenConfigs, err := parseTokenConfigs(tokens)
 	if err != nil {
 		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKENS: %w", err)
 	}
-	cfg.TokenConfigs = tokenConfigs
+	if tokenHasher != nil {
+		if err := checkTokenDigests(tokens); err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_TOKENS: %w", err)
+		}
+		if err := policy.checkTokenDigests(); err != nil {
+			return nil, err
+		}
+	}
+	cfg.TokenConfigs = policy.tokenConfigs()
+	maps.Copy(cfg.TokenConfigs, tokenConfigs)
+
+	planConfigs, err := parsePlanConfigs(getEnv("RATE_LIMIT_PLANS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PLANS: %w", err)
+	}
+	cfg.Plans = policy.planConfigs()
+	maps.Copy(cfg.Plans, planConfigs)
+
+	for _, token := range sortedKeys(cfg.TokenConfigs) {
+		if err := validatePlan(cfg.TokenConfigs[token], cfg.Plans); err != nil {
+			if _, ok := tokenConfigs[token]; ok {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_TOKENS: token %s: %w", token, err)
+			}
+			return nil, policy.invalidToken(token, err)
+		}
+	}
+
+	redisTokens, err := strconv.ParseBool(getEnv("RATE_LIMIT_REDIS_TOKENS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_REDIS_TOKENS: %w", err)
+	}
+	cfg.RedisTokens = redisTokens
+
+	tokenCacheTTL, err := time.ParseDuration(getEnv("RATE_LIMIT_TOKEN_CACHE_TTL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_CACHE_TTL: %w", err)
+	}
+	if tokenCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TOKEN_CACHE_TTL: must not be negative")
+	}
+	cfg.TokenCacheTTL = tokenCacheTTL
+
+	if err := loadGlobalLimit(cfg, policy); err != nil {
+		return nil, err
+	}
+
+	headerStyle, err := middleware.ParseHeaderStyle(getEnv("RATE_LIMIT_HEADERS", string(middleware.HeaderStyleLegacy)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_HEADERS: %w", err)
+	}
+	cfg.HeaderStyle = headerStyle
+
+	keyExtractor, err := parseKeyExtractor(getEnv("RATE_LIMIT_KEY", "header:API_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_KEY: %w", err)
+	}
+	cfg.KeyExtractor = keyExtractor
+
+	routePrecedence := policy.setting("RATE_LIMIT_ROUTE_PRECEDENCE", string(middleware.PrecedenceSpecific))
+	cfg.RoutePrecedence, err = middleware.ParsePrecedence(routePrecedence.value)
+	if err != nil {
+		return nil, routePrecedence.invalid(err)
+	}
+
+	routes, err := parseRoutes(getEnv("RATE_LIMIT_ROUTES", ""), cfg.RoutePrecedence)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES: %w", err)
+	}
+	cfg.Routes = mergeRoutes(policy.routeRules(), routes)
+	if err := validateRoutes(cfg.Routes, cfg.RoutePrecedence); err != nil {
+		return nil, fmt.Errorf("invalid routes: %w", err)
+	}
+	for i := range cfg.Routes {
+		prefix := &cfg.Routes[i].IPPrefix
+		if prefix.IPv4 == 0 {
+			prefix.IPv4 = cfg.IPPrefix.IPv4
+		}
+		if prefix.IPv6 == 0 {
+			prefix.IPv6 = cfg.IPPrefix.IPv6
+		}
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	cfg.TrustedProxies = trustedProxies
+
+	trustedProxyHops, err := strconv.Atoi(getEnv("TRUSTED_PROXY_HOPS", "1"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXY_HOPS: %w", err)
+	}
+	if trustedProxyHops < 1 {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXY_HOPS: must be at least 1")
+	}
+	cfg.TrustedProxyHops = trustedProxyHops
+
+	clientIPHeader, err := middleware.ParseIPHeader(getEnv("CLIENT_IP_HEADER", string(middleware.IPHeaderForwardedFor)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLIENT_IP_HEADER: %w", err)
+	}
+	cfg.ClientIPHeader = clientIPHeader
 
 	return cfg, nil
 }
 
+// loadGlobalLimit reads the service-wide limit; RATE_LIMIT_GLOBAL=0 disables it.
+func loadGlobalLimit(cfg *Config, policy *policyFile) error {
+	limit := policy.setting("RATE_LIMIT_GLOBAL", "0")
+	globalLimit, err := strconv.Atoi(limit.value)
+	if err != nil || globalLimit < 0 {
+		return limit.invalid(fmt.Errorf("%q (expected a non-negative number)", limit.value))
+	}
+	cfg.GlobalLimit = globalLimit
+
+	window := policy.setting("RATE_LIMIT_GLOBAL_WINDOW", "1")
+	cfg.GlobalWindow, err = parseWindow(window.value)
+	if err != nil {
+		return window.invalid(err)
+	}
+
+	reserved := policy.setting("RATE_LIMIT_GLOBAL_RESERVED", "0")
+	globalReserved, err := strconv.Atoi(reserved.value)
+	if err != nil || globalReserved < 0 || (globalLimit > 0 && globalReserved >= globalLimit) {
+		return reserved.invalid(fmt.Errorf("%q (expected 0 to the global limit minus 1)", reserved.value))
+	}
+	cfg.GlobalReserved = globalReserved
+
+	status := policy.setting("RATE_LIMIT_GLOBAL_STATUS", "503")
+	globalStatus, err := strconv.Atoi(status.value)
+	if err != nil || globalStatus < 400 || globalStatus > 599 {
+		return status.invalid(fmt.Errorf("%q (expected a 4xx or 5xx status)", status.value))
+	}
+	cfg.GlobalStatus = globalStatus
+
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		value = dotenv[key]
+	}
+	if value != "" {
 		return value
 	}
 	return defaultValue
 }
 
+// parseKeyExtractor builds the extractor selected by RATE_LIMIT_KEY.
+func parseKeyExtractor(spec string) (middleware.KeyExtractor, error) {
+	if strings.TrimSpace(spec) != "jwt" {
+		return middleware.ParseKeyExtractor(spec)
+	}
+
+	var reject bool
+	switch invalid := getEnv("JWT_INVALID_TOKEN", "ip"); invalid {
+	case "ip":
+	case "reject":
+		reject = true
+	default:
+		return nil, fmt.Errorf("invalid JWT_INVALID_TOKEN: %q (expected ip or reject)", invalid)
+	}
+
+	extractor, err := middleware.NewJWTKey(middleware.JWTConfig{
+		Secret:     []byte(getEnv("JWT_SECRET", "")),
+		JWKSFile:   getEnv("JWT_JWKS_FILE", ""),
+		KeyClaim:   getEnv("JWT_KEY_CLAIM", "sub"),
+		LimitClaim: getEnv("JWT_LIMIT_CLAIM", ""),
+		Reject:     reject,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt configuration: %w", err)
+	}
+	return extractor, nil
+}
+
+func parseIPPrefix(v4, v6 setting) (limiter.IPPrefix, error) {
+	ipv4, err := strconv.Atoi(v4.value)
+	if err != nil || ipv4 < 1 || ipv4 > 32 {
+		return limiter.IPPrefix{}, v4.invalid(fmt.Errorf("%q (expected 1-32)", v4.value))
+	}
+
+	ipv6, err := strconv.Atoi(v6.value)
+	if err != nil || ipv6 < 1 || ipv6 > 128 {
+		return limiter.IPPrefix{}, v6.invalid(fmt.Errorf("%q (expected 1-128)", v6.value))
+	}
+
+	return limiter.IPPrefix{IPv4: ipv4, IPv6: ipv6}, nil
+}
+
+// parseTokenConfigs parses RATE_LIMIT_TOKENS, whose tokens have their own limits or a plan.
 func parseTokenConfigs(s string) (map[string]limiter.TokenConfig, error) {
+	return parseConfigs(s, "token")
+}
+
+// parsePlanConfigs parses RATE_LIMIT_PLANS.
+func parsePlanConfigs(s string) (map[string]limiter.TokenConfig, error) {
+	configs, err := parseConfigs(s, "plan")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(configs) {
+		if configs[name].Plan != "" {
+			return nil, fmt.Errorf("invalid options for plan %s: a plan cannot use another plan", name)
+		}
+	}
+	return configs, nil
+}
+
+// parseConfigs parses the token configs of RATE_LIMIT_TOKENS or RATE_LIMIT_PLANS.
+func parseConfigs(s, kind string) (map[string]limiter.TokenConfig, error) {
 	configs := make(map[string]limiter.TokenConfig)
 	if s == "" {
 		return configs, nil
 	}
 
+	invalidFormat := func(entry string) error {
+		return fmt.Errorf("invalid %s config format: %s (expected %s:limit[/window]:blockSec[:options] or %s:plan=name[;options])", kind, entry, kind, kind)
+	}
+
 	entries := strings.Split(s, ",")
 	for _, entry := range entries {
 		parts := strings.Split(strings.TrimSpace(entry), ":")
-		if len(parts) != 3 {
-			return nil, fmt.Errorf("invalid token config format: %s (expected token:limit:blockSec)", entry)
+		if len(parts) < 2 || len(parts) > 4 {
+			return nil, invalidFormat(entry)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		var config limiter.TokenConfig
+		var options string
+		if len(parts) == 2 {
+			options = strings.TrimSpace(parts[1])
+		} else {
+			block, err := parseDuration(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid block duration for %s %s: %w", kind, name, err)
+			}
+
+			var limits []limiter.Limit
+			for _, spec := range strings.Split(parts[1], "+") {
+				limit, err := parseLimit(spec, block)
+				if err != nil {
+					return nil, fmt.Errorf("invalid limit for %s %s: %w", kind, name, err)
+				}
+				limits = append(limits, limit)
+			}
+
+			config = limiter.TokenConfig{
+				Limit:         limits[0].Limit,
+				BlockDuration: limits[0].BlockDuration,
+				Window:        limits[0].Window,
+			}
+			if len(limits) > 1 {
+				config.Limits = limits[1:]
+			}
+			if len(parts) == 4 {
+				options = strings.TrimSpace(parts[3])
+			}
+		}
+
+		if options != "" {
+			if err := parseTokenOptions(options, &config); err != nil {
+				return nil, fmt.Errorf("invalid options for %s %s: %w", kind, name, err)
+			}
+		}
+		if len(parts) == 2 && config.Plan == "" {
+			return nil, invalidFormat(entry)
 		}
 
-		token := strings.TrimSpace(parts[0])
-		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		// A token using a plan is checked once applied to the plan, see validatePlan.
+		if config.Plan == "" {
+			if config.Algorithm == "" {
+				config.Algorithm = limiter.FixedWindow
+			}
+			if err := validateCost(config); err != nil {
+				return nil, fmt.Errorf("invalid options for %s %s: %w", kind, name, err)
+			}
+			if err := validateLimits(config); err != nil {
+				return nil, fmt.Errorf("invalid limits for %s %s: %w", kind, name, err)
+			}
+		}
+
+		configs[name] = config
+	}
+
+	return configs, nil
+}
+
+// validatePlan checks that the plan of a token config exists and can take its overrides.
+func validatePlan(config limiter.TokenConfig, plans map[string]limiter.TokenConfig) error {
+	if config.Plan == "" {
+		return nil
+	}
+
+	plan, ok := plans[config.Plan]
+	if !ok {
+		return fmt.Errorf("unknown plan %q", config.Plan)
+	}
+	config = limiter.ApplyPlan(plan, config)
+	if err := validateCost(config); err != nil {
+		return err
+	}
+	return validateLimits(config)
+}
+
+// checkTokenDigests checks that the tokens of RATE_LIMIT_TOKENS are given by digest.
+func checkTokenDigests(s string) error {
+	if s == "" {
+		return nil
+	}
+	for i, entry := range strings.Split(s, ",") {
+		token, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if !middleware.IsTokenDigest(token) {
+			return fmt.Errorf("token %d is not a digest (hash it with cmd/hashtoken)", i+1)
+		}
+	}
+	return nil
+}
+
+// parseLimit parses one limit of a token config, "limit[/window[/blockSec]]".
+func parseLimit(s string, defaultBlock time.Duration) (limiter.Limit, error) {
+	fields := strings.Split(strings.TrimSpace(s), "/")
+	if len(fields) > 3 {
+		return limiter.Limit{}, fmt.Errorf("invalid limit %q (expected limit[/window[/blockSec]])", s)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return limiter.Limit{}, err
+	}
+	l := limiter.Limit{Limit: limit, BlockDuration: defaultBlock}
+
+	if len(fields) > 1 {
+		l.Window, err = parseWindow(fields[1])
 		if err != nil {
-			return nil, fmt.Errorf("invalid limit for token %s: %w", token, err)
+			return limiter.Limit{}, err
+		}
+	}
+	if len(fields) > 2 {
+		l.BlockDuration, err = parseDuration(fields[2])
+		if err != nil {
+			return limiter.Limit{}, fmt.Errorf("invalid block %q", fields[2])
+		}
+	}
+	return l, nil
+}
+
+// validateLimits checks that the limits of a multi-limit config can be enforced together.
+func validateLimits(config limiter.TokenConfig) error {
+	if len(config.Limits) == 0 {
+		return nil
+	}
+	if config.Algorithm != limiter.FixedWindow {
+		return fmt.Errorf("multiple limits require the %s algorithm", limiter.FixedWindow)
+	}
+
+	windows := map[time.Duration]bool{max(config.Window.Truncate(time.Second), time.Second): true}
+	for _, l := range config.Limits {
+		window := max(l.Window.Truncate(time.Second), time.Second)
+		if windows[window] {
+			return fmt.Errorf("duplicate window %v", window)
 		}
+		windows[window] = true
+	}
+	return nil
+}
+
+// parseDuration parses a duration in seconds ("300"), as a Go duration ("5m") or in days ("1d").
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
 
-		blockSec, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	var d time.Duration
+	if seconds, err := strconv.Atoi(s); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
 		if err != nil {
-			return nil, fmt.Errorf("invalid block duration for token %s: %w", token, err)
+			return 0, fmt.Errorf("invalid duration %q", s)
 		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
 
-		configs[token] = limiter.TokenConfig{
-			Limit:         limit,
-			BlockDuration: time.Duration(blockSec) * time.Second,
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+	return d, nil
+}
+
+// parseWindow parses a window length in any format accepted by parseDuration.
+func parseWindow(s string) (time.Duration, error) {
+	window, err := parseDuration(s)
+	if err != nil || window == 0 {
+		return 0, fmt.Errorf("invalid window %q: must be a positive duration", strings.TrimSpace(s))
+	}
+	return window, nil
+}
+
+// parseRoutes parses comma separated route rules of semicolon separated key=value fields.
+func parseRoutes(s string, precedence middleware.Precedence) ([]RouteRule, error) {
+	var routes []RouteRule
+	if strings.TrimSpace(s) == "" {
+		return routes, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		route := RouteRule{Algorithm: limiter.FixedWindow}
+		for _, field := range strings.Split(strings.TrimSpace(entry), ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid route field %q (expected key=value)", field)
+			}
+			if err := setRouteField(&route, strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				return nil, err
+			}
 		}
+
+		if route.Limit == 0 {
+			return nil, fmt.Errorf("route %s: missing limit", route.ID)
+		}
+		routes = append(routes, route)
 	}
 
-	return configs, nil
+	if err := validateRoutes(routes, precedence); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// setRouteField sets one field of a route rule from its string value.
+func setRouteField(route *RouteRule, key, value string) error {
+	switch key {
+	case "id":
+		route.ID = value
+	case "pattern":
+		route.Pattern = value
+	case "limit":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid limit %q", value)
+		}
+		route.Limit = limit
+	case "block":
+		block, err := parseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid block %q", value)
+		}
+		route.BlockDuration = block
+	case "window":
+		window, err := parseWindow(value)
+		if err != nil {
+			return err
+		}
+		route.Window = window
+	case "algorithm":
+		algorithm, err := limiter.ParseAlgorithm(value)
+		if err != nil {
+			return err
+		}
+		route.Algorithm = algorithm
+	case "burst":
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return fmt.Errorf("invalid burst %q", value)
+		}
+		route.Burst = burst
+	case "prefix_v4":
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 1 || bits > 32 {
+			return fmt.Errorf("invalid prefix_v4 %q (expected 1-32)", value)
+		}
+		route.IPPrefix.IPv4 = bits
+	case "prefix_v6":
+		bits, err := strconv.Atoi(value)
+		if err != nil || bits < 1 || bits > 128 {
+			return fmt.Errorf("invalid prefix_v6 %q (expected 1-128)", value)
+		}
+		route.IPPrefix.IPv6 = bits
+	case "key":
+		route.keySpec = value
+		if value == "ip" {
+			route.Key = nil
+			return nil
+		}
+		extractor, err := parseKeyExtractor(value)
+		if err != nil {
+			return err
+		}
+		route.Key = extractor
+	default:
+		return fmt.Errorf("unknown route field %q", key)
+	}
+	return nil
+}
+
+// validateRoutes checks that the route ids are unique and the patterns valid.
+func validateRoutes(routes []RouteRule, precedence middleware.Precedence) error {
+	table := make([]middleware.Route, len(routes))
+	for i, route := range routes {
+		table[i] = middleware.Route{ID: route.ID, Pattern: route.Pattern}
+	}
+	_, err := middleware.NewRouteTable(table, precedence)
+	return err
+}
+
+// mergeRoutes returns base with the routes of overrides replacing those with the same id.
+func mergeRoutes(base, overrides []RouteRule) []RouteRule {
+	routes := slices.Clone(base)
+	for _, override := range overrides {
+		i := slices.IndexFunc(routes, func(route RouteRule) bool { return route.ID == override.ID })
+		if i < 0 {
+			routes = append(routes, override)
+			continue
+		}
+		routes[i] = override
+	}
+	return routes
+}
+
+// parseTokenOptions applies semicolon separated key=value options to a token config.
+func parseTokenOptions(s string, config *limiter.TokenConfig) error {
+	for _, option := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return fmt.Errorf("invalid option %q (expected key=value)", option)
+		}
+		if err := setTokenOption(config, strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTokenOption sets one option of a token config from its string value.
+func setTokenOption(config *limiter.TokenConfig, key, value string) error {
+	switch key {
+	case "algorithm":
+		algorithm, err := limiter.ParseAlgorithm(value)
+		if err != nil {
+			return err
+		}
+		config.Algorithm = algorithm
+	case "burst":
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return fmt.Errorf("invalid burst %q", value)
+		}
+		config.Burst = burst
+	case "cost":
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost <= 0 {
+			return fmt.Errorf("invalid cost %q", value)
+		}
+		config.Cost = cost
+	case "max_wait":
+		maxWait, err := time.ParseDuration(value)
+		if err != nil || maxWait < 0 {
+			return fmt.Errorf("invalid max_wait %q", value)
+		}
+		config.MaxWait = maxWait
+	case "concurrency":
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 0 {
+			return fmt.Errorf("invalid concurrency %q", value)
+		}
+		config.Concurrency = concurrency
+	case "combine_ip":
+		combine, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid combine_ip %q", value)
+		}
+		config.CombineIP = combine
+	case "pair_limit":
+		pairLimit, err := strconv.Atoi(value)
+		if err != nil || pairLimit < 0 {
+			return fmt.Errorf("invalid pair_limit %q", value)
+		}
+		config.PairLimit = pairLimit
+	case "plan":
+		if value == "" {
+			return fmt.Errorf("invalid plan %q", value)
+		}
+		config.Plan = value
+	case "priority":
+		priority, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", value)
+		}
+		config.Priority = priority
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
+
+// validateCost checks that a request's cost fits in the token bucket.
+func validateCost(config limiter.TokenConfig) error {
+	capacity := config.Burst
+	if capacity == 0 {
+		capacity = config.Limit
+	}
+	if config.Cost > capacity {
+		return fmt.Errorf("cost %d exceeds bucket capacity %d", config.Cost, capacity)
+	}
+	return nil
 }
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
 )
 
 func TestLoad_DefaultValues(t *testing.T) {
@@ -33,6 +39,10 @@ func TestLoad_DefaultValues(t *testing.T) {
 	if len(cfg.TokenConfigs) != 0 {
 		t.Errorf("expected empty TokenConfigs, got %d items", len(cfg.TokenConfigs))
 	}
+
+	if cfg.HeaderStyle != middleware.HeaderStyleLegacy {
+		t.Errorf("expected default HeaderStyle 'legacy', got %s", cfg.HeaderStyle)
+	}
 }
 
 func TestLoad_CustomValues(t *testing.T) {
@@ -159,6 +169,21 @@ func TestLoad_InvalidTokenFormat(t *testing.T) {
 		{"invalid limit", "token1:invalid:300"},
 		{"invalid block duration", "token1:100:invalid"},
 		{"too many parts", "token1:100:300:extra"},
+		{"five parts", "token1:100:300:algorithm=fixed_window:extra"},
+		{"unknown option", "token1:100:300:color=blue"},
+		{"invalid algorithm", "token1:100:300:algorithm=magic"},
+		{"invalid burst", "token1:100:300:burst=many"},
+		{"invalid max wait", "token1:100:300:max_wait=soon"},
+		{"invalid concurrency", "token1:100:300:concurrency=-1"},
+		{"invalid combine_ip", "token1:100:300:combine_ip=maybe"},
+		{"invalid pair_limit", "token1:100:300:pair_limit=-1"},
+		{"cost exceeds capacity", "token1:10:0:algorithm=token_bucket;cost=20"},
+		{"invalid window", "token1:100/fortnight:300"},
+		{"zero window", "token1:100/0s:300"},
+		{"invalid limit block", "token1:10/1s+300/1m/soon:300"},
+		{"too many limit fields", "token1:10/1s/0/extra:300"},
+		{"duplicate limit window", "token1:10/60+300/1m:300"},
+		{"multiple limits with sliding window", "token1:10/1s+300/1m:300:algorithm=sliding_window_log"},
 	}
 
 	for _, tt := range tests {
@@ -212,6 +237,66 @@ func TestLoad_TokensWithSpaces(t *testing.T) {
 	}
 }
 
+func TestParseTokenConfigs_Plan(t *testing.T) {
+	configs, err := parseTokenConfigs("abc123:plan=pro,xyz789:500/1m:0:plan=pro;concurrency=5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config := configs["abc123"]; config.Plan != "pro" || config.Limit != 0 || config.Algorithm != "" {
+		t.Errorf("expected abc123 to only reference the plan, got %+v", config)
+	}
+	if config := configs["xyz789"]; config.Plan != "pro" || config.Limit != 500 || config.Window != time.Minute || config.Concurrency != 5 {
+		t.Errorf("expected xyz789 to override the plan's limit and concurrency, got %+v", config)
+	}
+
+	for _, tokens := range []string{"abc123:burst=10", "abc123:plan=", "abc123:plan=pro;bogus=1"} {
+		if _, err := parseTokenConfigs(tokens); err == nil {
+			t.Errorf("expected error for tokens %q", tokens)
+		}
+	}
+}
+
+func TestLoad_Plans(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_PLANS", "free:10/1m:60,pro:100/1m+10000/1d:300:priority=true")
+	os.Setenv("RATE_LIMIT_TOKENS", "abc123:plan=free,xyz789:plan=pro;burst=150,plain:5:0")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pro := cfg.Plans["pro"]
+	if pro.Limit != 100 || len(pro.Limits) != 1 || !pro.Priority || pro.Algorithm != limiter.FixedWindow {
+		t.Errorf("unexpected pro plan: %+v", pro)
+	}
+	if cfg.TokenConfigs["xyz789"].Plan != "pro" || cfg.TokenConfigs["plain"].Plan != "" {
+		t.Errorf("unexpected tokens: %+v", cfg.TokenConfigs)
+	}
+	if _, ok := cfg.TokenConfigs["pro"]; ok {
+		t.Error("expected plans not to be configured as tokens")
+	}
+
+	tests := []struct {
+		plans  string
+		tokens string
+	}{
+		{"pro:100:60:plan=free", ""},
+		{"pro:plan=free", ""},
+		{"pro:100:60", "abc123:plan=gold"},
+		{"pro:100:60", "abc123:plan=pro;cost=200"},
+		{"pro:100/1m+10000/1d:60", "abc123:plan=pro;algorithm=gcra"},
+	}
+	for _, tt := range tests {
+		os.Setenv("RATE_LIMIT_PLANS", tt.plans)
+		os.Setenv("RATE_LIMIT_TOKENS", tt.tokens)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for plans %q and tokens %q", tt.plans, tt.tokens)
+		}
+	}
+}
+
 func TestParseTokenConfigs_EmptyString(t *testing.T) {
 	configs, err := parseTokenConfigs("")
 	if err != nil {
@@ -266,6 +351,49 @@ func TestGetEnv_ReturnsDefault(t *testing.T) {
 	}
 }
 
+func TestLoad_DotEnv(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	t.Cleanup(func() { dotenv = nil })
+
+	writeDotEnv := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(".env", []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write .env: %v", err)
+		}
+	}
+
+	writeDotEnv("RATE_LIMIT_IP=20\nRATE_LIMIT_TOKENS=abc123:100:60\nREDIS_ADDR=localhost:6379\n")
+	os.Setenv("REDIS_ADDR", "redis.internal:6379")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPLimit != 20 || cfg.TokenConfigs["abc123"].Limit != 100 {
+		t.Errorf("expected the .env limits, got ip %d and tokens %+v", cfg.IPLimit, cfg.TokenConfigs)
+	}
+	if cfg.RedisAddr != "redis.internal:6379" {
+		t.Errorf("expected the environment to take precedence over .env, got %s", cfg.RedisAddr)
+	}
+
+	// Loading again picks up the edited file.
+	writeDotEnv("RATE_LIMIT_IP=30\nRATE_LIMIT_TOKENS=xyz789:50:60\n")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPLimit != 30 {
+		t.Errorf("expected the edited IP limit 30, got %d", cfg.IPLimit)
+	}
+	if _, ok := cfg.TokenConfigs["abc123"]; ok || cfg.TokenConfigs["xyz789"].Limit != 50 {
+		t.Errorf("expected the edited tokens, got %+v", cfg.TokenConfigs)
+	}
+	if _, ok := os.LookupEnv("RATE_LIMIT_IP"); ok {
+		t.Error("expected .env variables not to be exported to the environment")
+	}
+}
+
 func TestLoad_NegativeValues(t *testing.T) {
 	os.Clearenv()
 	os.Setenv("RATE_LIMIT_IP", "-10")
@@ -295,3 +423,724 @@ func TestLoad_ZeroValues(t *testing.T) {
 		t.Errorf("expected IPBlockDuration 0, got %v", cfg.IPBlockDuration)
 	}
 }
+
+func TestLoad_HeaderStyle(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_HEADERS", "both")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.HeaderStyle != middleware.HeaderStyleBoth {
+		t.Errorf("expected HeaderStyle 'both', got %s", cfg.HeaderStyle)
+	}
+}
+
+func TestLoad_InvalidHeaderStyle(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_HEADERS", "fancy")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_HEADERS")
+	}
+}
+
+func TestLoad_IPAlgorithm(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPAlgorithm != limiter.FixedWindow {
+		t.Errorf("expected default IPAlgorithm fixed_window, got %s", cfg.IPAlgorithm)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_ALGORITHM", "sliding_window_log")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPAlgorithm != limiter.SlidingWindowLog {
+		t.Errorf("expected IPAlgorithm sliding_window_log, got %s", cfg.IPAlgorithm)
+	}
+}
+
+func TestLoad_InvalidIPAlgorithm(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_IP_ALGORITHM", "magic")
+
+	_, err := Load()
+	if err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_IP_ALGORITHM")
+	}
+}
+
+func TestParseTokenConfigs_AlgorithmOption(t *testing.T) {
+	configs, err := parseTokenConfigs("abc123:100:300:algorithm=sliding_window_log,xyz789:50:600")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if configs["abc123"].Algorithm != limiter.SlidingWindowLog {
+		t.Errorf("expected abc123 algorithm sliding_window_log, got %s", configs["abc123"].Algorithm)
+	}
+	if configs["xyz789"].Algorithm != limiter.FixedWindow {
+		t.Errorf("expected xyz789 algorithm fixed_window, got %s", configs["xyz789"].Algorithm)
+	}
+}
+
+func TestLoad_IPWindow(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPWindow != time.Second {
+		t.Errorf("expected default IPWindow 1s, got %v", cfg.IPWindow)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_WINDOW", "60")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPWindow != time.Minute {
+		t.Errorf("expected IPWindow 1m, got %v", cfg.IPWindow)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_WINDOW", "1h")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPWindow != time.Hour {
+		t.Errorf("expected IPWindow 1h, got %v", cfg.IPWindow)
+	}
+}
+
+func TestParseTokenConfigs_Window(t *testing.T) {
+	configs, err := parseTokenConfigs("abc123:1000/1m:300,daily:10000/1d:0,legacy:10/30:0,plain:5:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]time.Duration{
+		"abc123": time.Minute,
+		"daily":  24 * time.Hour,
+		"legacy": 30 * time.Second,
+		"plain":  0,
+	}
+	for token, window := range want {
+		if configs[token].Window != window {
+			t.Errorf("expected %s window %v, got %v", token, window, configs[token].Window)
+		}
+	}
+	if configs["abc123"].Limit != 1000 || configs["abc123"].BlockDuration != 300*time.Second {
+		t.Errorf("expected abc123 limit 1000 and block 300s, got %+v", configs["abc123"])
+	}
+}
+
+func TestParseTokenConfigs_MultipleLimits(t *testing.T) {
+	configs, err := parseTokenConfigs("abc123:10/1s/0+300/1m+10000/1d/86400:300")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := configs["abc123"]
+	if config.Limit != 10 || config.Window != time.Second || config.BlockDuration != 0 {
+		t.Errorf("expected first limit 10/1s without block, got %+v", config)
+	}
+
+	want := []limiter.Limit{
+		{Limit: 300, Window: time.Minute, BlockDuration: 300 * time.Second},
+		{Limit: 10000, Window: 24 * time.Hour, BlockDuration: 24 * time.Hour},
+	}
+	if len(config.Limits) != len(want) {
+		t.Fatalf("expected %d further limits, got %+v", len(want), config.Limits)
+	}
+	for i := range want {
+		if config.Limits[i] != want[i] {
+			t.Errorf("limit %d: expected %+v, got %+v", i, want[i], config.Limits[i])
+		}
+	}
+}
+
+func TestParseTokenConfigs_CombinedLimits(t *testing.T) {
+	configs, err := parseTokenConfigs("abc123:100:300:combine_ip=true;pair_limit=20,xyz789:50:600")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !configs["abc123"].CombineIP || configs["abc123"].PairLimit != 20 {
+		t.Errorf("expected abc123 to combine ip with pair limit 20, got %+v", configs["abc123"])
+	}
+	if configs["xyz789"].CombineIP || configs["xyz789"].PairLimit != 0 {
+		t.Errorf("expected xyz789 to replace the ip limit, got %+v", configs["xyz789"])
+	}
+}
+
+func TestLoad_InvalidIPWindow(t *testing.T) {
+	for _, value := range []string{"invalid", "0", "-5", "-1m", "xd"} {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_IP_WINDOW", value)
+
+		_, err := Load()
+		if err == nil {
+			t.Errorf("expected error for RATE_LIMIT_IP_WINDOW %q", value)
+		}
+	}
+}
+
+func TestParseTokenConfigs_TokenBucketOptions(t *testing.T) {
+	configs, err := parseTokenConfigs("partner:50:0:algorithm=token_bucket;burst=200;cost=2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := configs["partner"]
+	if config.Algorithm != limiter.TokenBucket {
+		t.Errorf("expected algorithm token_bucket, got %s", config.Algorithm)
+	}
+	if config.Limit != 50 {
+		t.Errorf("expected limit 50, got %d", config.Limit)
+	}
+	if config.Burst != 200 {
+		t.Errorf("expected burst 200, got %d", config.Burst)
+	}
+	if config.Cost != 2 {
+		t.Errorf("expected cost 2, got %d", config.Cost)
+	}
+}
+
+func TestLoad_IPBurst(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_IP_BURST", "40")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPBurst != 40 {
+		t.Errorf("expected IPBurst 40, got %d", cfg.IPBurst)
+	}
+}
+
+func TestParseTokenConfigs_LeakyBucketOptions(t *testing.T) {
+	configs, err := parseTokenConfigs("batch:20:0:algorithm=leaky_bucket;burst=100;max_wait=2s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := configs["batch"]
+	if config.Algorithm != limiter.LeakyBucket {
+		t.Errorf("expected algorithm leaky_bucket, got %s", config.Algorithm)
+	}
+	if config.MaxWait != 2*time.Second {
+		t.Errorf("expected max wait 2s, got %v", config.MaxWait)
+	}
+}
+
+func TestLoad_IPMaxWait(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_IP_MAX_WAIT", "500ms")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPMaxWait != 500*time.Millisecond {
+		t.Errorf("expected IPMaxWait 500ms, got %v", cfg.IPMaxWait)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_MAX_WAIT", "later")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_IP_MAX_WAIT")
+	}
+}
+
+func TestLoad_Concurrency(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPConcurrency != 0 {
+		t.Errorf("expected concurrency limit disabled by default, got %d", cfg.IPConcurrency)
+	}
+	if cfg.LeaseTTL != 30*time.Second {
+		t.Errorf("expected default LeaseTTL 30s, got %v", cfg.LeaseTTL)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_CONCURRENCY", "4")
+	os.Setenv("RATE_LIMIT_CONCURRENCY_LEASE", "1m")
+	os.Setenv("RATE_LIMIT_TOKENS", "abc123:100:300:concurrency=20")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPConcurrency != 4 {
+		t.Errorf("expected IPConcurrency 4, got %d", cfg.IPConcurrency)
+	}
+	if cfg.LeaseTTL != time.Minute {
+		t.Errorf("expected LeaseTTL 1m, got %v", cfg.LeaseTTL)
+	}
+	if cfg.TokenConfigs["abc123"].Concurrency != 20 {
+		t.Errorf("expected abc123 concurrency 20, got %d", cfg.TokenConfigs["abc123"].Concurrency)
+	}
+}
+
+func TestLoad_InvalidConcurrency(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_IP_CONCURRENCY", "many")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_IP_CONCURRENCY")
+	}
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONCURRENCY_LEASE", "0s")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for non-positive RATE_LIMIT_CONCURRENCY_LEASE")
+	}
+}
+
+func TestLoad_StoreBackend(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StoreBackend != StoreBackendRedis {
+		t.Errorf("expected default StoreBackend 'redis', got %s", cfg.StoreBackend)
+	}
+	if cfg.MemoryMaxEntries != 100000 {
+		t.Errorf("expected default MemoryMaxEntries 100000, got %d", cfg.MemoryMaxEntries)
+	}
+
+	os.Setenv("STORE_BACKEND", "memory")
+	os.Setenv("MEMORY_STORE_MAX_ENTRIES", "5000")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StoreBackend != StoreBackendMemory {
+		t.Errorf("expected StoreBackend 'memory', got %s", cfg.StoreBackend)
+	}
+	if cfg.MemoryMaxEntries != 5000 {
+		t.Errorf("expected MemoryMaxEntries 5000, got %d", cfg.MemoryMaxEntries)
+	}
+}
+
+func TestLoad_InvalidStoreBackend(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("STORE_BACKEND", "memcached")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid STORE_BACKEND")
+	}
+
+	os.Clearenv()
+	os.Setenv("MEMORY_STORE_MAX_ENTRIES", "-1")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for negative MEMORY_STORE_MAX_ENTRIES")
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 0 {
+		t.Errorf("expected no trusted proxies by default, got %v", cfg.TrustedProxies)
+	}
+	if cfg.TrustedProxyHops != 1 {
+		t.Errorf("expected default TrustedProxyHops 1, got %d", cfg.TrustedProxyHops)
+	}
+	if cfg.ClientIPHeader != middleware.IPHeaderForwardedFor {
+		t.Errorf("expected default ClientIPHeader 'x-forwarded-for', got %s", cfg.ClientIPHeader)
+	}
+
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,fd00::/8")
+	os.Setenv("TRUSTED_PROXY_HOPS", "2")
+	os.Setenv("CLIENT_IP_HEADER", "Forwarded")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 {
+		t.Errorf("expected 2 trusted proxies, got %v", cfg.TrustedProxies)
+	}
+	if cfg.TrustedProxyHops != 2 {
+		t.Errorf("expected TrustedProxyHops 2, got %d", cfg.TrustedProxyHops)
+	}
+	if cfg.ClientIPHeader != middleware.IPHeaderForwarded {
+		t.Errorf("expected ClientIPHeader 'forwarded', got %s", cfg.ClientIPHeader)
+	}
+}
+
+func TestLoad_InvalidTrustedProxies(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/99")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid TRUSTED_PROXIES")
+	}
+
+	os.Clearenv()
+	os.Setenv("TRUSTED_PROXY_HOPS", "0")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for TRUSTED_PROXY_HOPS below 1")
+	}
+
+	os.Clearenv()
+	os.Setenv("CLIENT_IP_HEADER", "True-Client-IP")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for unknown CLIENT_IP_HEADER")
+	}
+}
+
+func TestLoad_IPPrefix(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPPrefix != (limiter.IPPrefix{IPv4: 32, IPv6: 64}) {
+		t.Errorf("expected default IPPrefix /32 and /64, got %+v", cfg.IPPrefix)
+	}
+
+	os.Setenv("RATE_LIMIT_IP_PREFIX_V4", "24")
+	os.Setenv("RATE_LIMIT_IP_PREFIX_V6", "48")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.IPPrefix != (limiter.IPPrefix{IPv4: 24, IPv6: 48}) {
+		t.Errorf("expected IPPrefix /24 and /48, got %+v", cfg.IPPrefix)
+	}
+}
+
+func TestLoad_InvalidIPPrefix(t *testing.T) {
+	for _, env := range []struct{ key, value string }{
+		{"RATE_LIMIT_IP_PREFIX_V4", "33"},
+		{"RATE_LIMIT_IP_PREFIX_V4", "0"},
+		{"RATE_LIMIT_IP_PREFIX_V6", "129"},
+		{"RATE_LIMIT_IP_PREFIX_V6", "abc"},
+	} {
+		os.Clearenv()
+		os.Setenv(env.key, env.value)
+
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for %s=%s", env.key, env.value)
+		}
+	}
+}
+
+func TestLoad_KeyExtractor(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("API_KEY", "abc123")
+	if got := cfg.KeyExtractor.ExtractKey(req); got != "abc123" {
+		t.Errorf("expected default extractor to read API_KEY, got %q", got)
+	}
+
+	os.Setenv("RATE_LIMIT_KEY", "bearer|header:API_KEY")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer xyz789")
+	if got := cfg.KeyExtractor.ExtractKey(req); got != "xyz789" {
+		t.Errorf("expected bearer token, got %q", got)
+	}
+}
+
+func TestLoad_InvalidKeyExtractor(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_KEY", "header")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_KEY")
+	}
+}
+
+func TestLoad_JWTKeyExtractor(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_KEY", "jwt")
+	os.Setenv("JWT_SECRET", "secret")
+	os.Setenv("JWT_INVALID_TOKEN", "reject")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := cfg.KeyExtractor.(*middleware.JWTKey); !ok {
+		t.Errorf("expected JWT key extractor, got %T", cfg.KeyExtractor)
+	}
+
+	os.Unsetenv("JWT_SECRET")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for jwt without secret or JWKS file")
+	}
+
+	os.Setenv("JWT_SECRET", "secret")
+	os.Setenv("JWT_INVALID_TOKEN", "ignore")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid JWT_INVALID_TOKEN")
+	}
+}
+
+func TestLoad_Routes(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Routes) != 0 {
+		t.Errorf("expected no routes by default, got %d", len(cfg.Routes))
+	}
+	if cfg.RoutePrecedence != middleware.PrecedenceSpecific {
+		t.Errorf("expected default RoutePrecedence 'specific', got %s", cfg.RoutePrecedence)
+	}
+
+	os.Setenv("RATE_LIMIT_ROUTES", "id=login;pattern=POST /login;limit=5;window=60;block=300;algorithm=sliding_window_log;prefix_v4=24, id=search;pattern=GET /search;limit=100;key=header:API_KEY")
+	os.Setenv("RATE_LIMIT_ROUTE_PRECEDENCE", "first")
+	os.Setenv("RATE_LIMIT_IP_PREFIX_V6", "56")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RoutePrecedence != middleware.PrecedenceFirst {
+		t.Errorf("expected RoutePrecedence 'first', got %s", cfg.RoutePrecedence)
+	}
+	if len(cfg.Routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(cfg.Routes))
+	}
+
+	login := cfg.Routes[0]
+	if login.ID != "login" || login.Pattern != "POST /login" || login.Limit != 5 {
+		t.Errorf("unexpected login route: %+v", login)
+	}
+	if login.Window != time.Minute || login.BlockDuration != 300*time.Second || login.Algorithm != limiter.SlidingWindowLog {
+		t.Errorf("unexpected login limits: %+v", login)
+	}
+	if login.Key != nil {
+		t.Error("expected login route to be limited by ip")
+	}
+	if login.IPPrefix != (limiter.IPPrefix{IPv4: 24, IPv6: 56}) {
+		t.Errorf("expected login route to use /24 and the IP limit's /56, got %+v", login.IPPrefix)
+	}
+
+	search := cfg.Routes[1]
+	if search.Algorithm != limiter.FixedWindow || search.Key == nil {
+		t.Errorf("expected search route with fixed window and key extractor, got %+v", search)
+	}
+	if search.IPPrefix != cfg.IPPrefix {
+		t.Errorf("expected search route to use the IP limit's prefix, got %+v", search.IPPrefix)
+	}
+}
+
+func TestLoad_InvalidRoutes(t *testing.T) {
+	invalid := []string{
+		"id=login;pattern=POST /login",
+		"id=login;pattern=POST /login;limit=0",
+		"id=login;pattern=POST /login;limit=5;window=0",
+		"id=login;pattern=POST /login;limit=5;key=header",
+		"id=login;pattern=POST /login;limit=5;color=red",
+		"id=login;pattern=POST /login;limit=5;prefix_v4=0",
+		"id=login;pattern=POST /login;limit=5;prefix_v4=33",
+		"id=login;pattern=POST /login;limit=5;prefix_v6=129",
+		"id=login;pattern=POST /login;limit=5;prefix_v6=wide",
+		"id=login;pattern=POST /{bad;limit=5",
+		"pattern=POST /login;limit=5",
+		"id=a;pattern=/a;limit=5,id=a;pattern=/b;limit=5",
+		"id=a;pattern=/{x}/b;limit=5,id=b;pattern=/a/{y};limit=5",
+	}
+
+	for _, routes := range invalid {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_ROUTES", routes)
+
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for RATE_LIMIT_ROUTES=%s", routes)
+		}
+	}
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_ROUTE_PRECEDENCE", "last")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid RATE_LIMIT_ROUTE_PRECEDENCE")
+	}
+}
+
+func TestLoad_GlobalLimit(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.GlobalLimit != 0 || cfg.GlobalWindow != time.Second || cfg.GlobalStatus != http.StatusServiceUnavailable {
+		t.Errorf("expected global limit disabled with 1s window and status 503, got %d %v %d", cfg.GlobalLimit, cfg.GlobalWindow, cfg.GlobalStatus)
+	}
+
+	os.Setenv("RATE_LIMIT_GLOBAL", "1000")
+	os.Setenv("RATE_LIMIT_GLOBAL_WINDOW", "1m")
+	os.Setenv("RATE_LIMIT_GLOBAL_RESERVED", "100")
+	os.Setenv("RATE_LIMIT_GLOBAL_STATUS", "429")
+	os.Setenv("RATE_LIMIT_TOKENS", "premium:100:0:priority=true")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.GlobalLimit != 1000 || cfg.GlobalWindow != time.Minute || cfg.GlobalReserved != 100 || cfg.GlobalStatus != http.StatusTooManyRequests {
+		t.Errorf("unexpected global limit %d %v reserved %d status %d", cfg.GlobalLimit, cfg.GlobalWindow, cfg.GlobalReserved, cfg.GlobalStatus)
+	}
+	if !cfg.TokenConfigs["premium"].Priority {
+		t.Error("expected premium token to have priority")
+	}
+}
+
+func TestLoad_InvalidGlobalLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"invalid limit", map[string]string{"RATE_LIMIT_GLOBAL": "many"}},
+		{"negative limit", map[string]string{"RATE_LIMIT_GLOBAL": "-1"}},
+		{"invalid window", map[string]string{"RATE_LIMIT_GLOBAL_WINDOW": "0"}},
+		{"reserved exceeds limit", map[string]string{"RATE_LIMIT_GLOBAL": "10", "RATE_LIMIT_GLOBAL_RESERVED": "10"}},
+		{"negative reserved", map[string]string{"RATE_LIMIT_GLOBAL_RESERVED": "-1"}},
+		{"invalid status", map[string]string{"RATE_LIMIT_GLOBAL_STATUS": "200"}},
+		{"invalid priority", map[string]string{"RATE_LIMIT_TOKENS": "premium:100:0:priority=yes please"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for key, value := range tt.env {
+				os.Setenv(key, value)
+			}
+
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for %v", tt.env)
+			}
+		})
+	}
+}
+
+func TestLoad_RedisTokens(t *testing.T) {
+	os.Clearenv()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RedisTokens || cfg.TokenCacheTTL != time.Minute {
+		t.Errorf("expected Redis tokens disabled with a 1m cache, got %v %v", cfg.RedisTokens, cfg.TokenCacheTTL)
+	}
+
+	os.Setenv("RATE_LIMIT_REDIS_TOKENS", "true")
+	os.Setenv("RATE_LIMIT_TOKEN_CACHE_TTL", "10s")
+
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.RedisTokens || cfg.TokenCacheTTL != 10*time.Second {
+		t.Errorf("expected Redis tokens enabled with a 10s cache, got %v %v", cfg.RedisTokens, cfg.TokenCacheTTL)
+	}
+
+	for env, value := range map[string]string{
+		"RATE_LIMIT_REDIS_TOKENS":    "maybe",
+		"RATE_LIMIT_TOKEN_CACHE_TTL": "-1s",
+	} {
+		os.Clearenv()
+		os.Setenv(env, value)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for %s=%s", env, value)
+		}
+	}
+}
+
+func TestLoad_TokenHash(t *testing.T) {
+	digest := middleware.SHA256Token("abc123")
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_TOKEN_HASH", "sha256")
+	os.Setenv("RATE_LIMIT_TOKENS", digest+":100:300")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TokenHasher == nil || cfg.TokenHasher("abc123") != digest {
+		t.Error("expected the SHA-256 token hasher")
+	}
+	if cfg.TokenConfigs[digest].Limit != 100 {
+		t.Errorf("expected the token to be configured by digest, got %+v", cfg.TokenConfigs)
+	}
+
+	os.Setenv("RATE_LIMIT_TOKENS", digest+":100:300,abc123:10:60")
+	_, err = Load()
+	if err == nil {
+		t.Fatal("expected error for a token not given by digest")
+	}
+	if strings.Contains(err.Error(), "abc123") {
+		t.Errorf("expected the error not to echo the token, got %q", err)
+	}
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_TOKEN_HASH", "hmac-sha256")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for hmac-sha256 without RATE_LIMIT_TOKEN_SECRET")
+	}
+	os.Setenv("RATE_LIMIT_TOKEN_SECRET", "secret")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TokenHasher("abc123") != middleware.HMACToken([]byte("secret"))("abc123") {
+		t.Error("expected the HMAC token hasher")
+	}
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_TOKEN_HASH", "md5")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for an unknown RATE_LIMIT_TOKEN_HASH")
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+// Diff describes the changes from old to cfg, one line per changed setting.
+func Diff(old, cfg *Config) []string {
+	var changes []string
+
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*cfg)
+	for i := range oldValue.NumField() {
+		name := oldValue.Type().Field(i).Name
+		switch name {
+		case "TokenConfigs", "Plans", "Routes", "KeyExtractor", "TokenHasher":
+			continue
+		}
+
+		from, to := fmt.Sprint(oldValue.Field(i).Interface()), fmt.Sprint(newValue.Field(i).Interface())
+		switch {
+		case from == to:
+		case name == "RedisPassword":
+			changes = append(changes, "RedisPassword changed")
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, from, to))
+		}
+	}
+
+	changes = append(changes, diffTokens("token", old.TokenConfigs, cfg.TokenConfigs)...)
+	changes = append(changes, diffTokens("plan", old.Plans, cfg.Plans)...)
+	return append(changes, diffRoutes(old.Routes, cfg.Routes)...)
+}
+
+// diffTokens describes the changes to the token configs of kind, tokens or plans.
+func diffTokens(kind string, old, configs map[string]limiter.TokenConfig) []string {
+	var changes []string
+	for _, name := range sortedKeys(old, configs) {
+		from, inOld := old[name]
+		to, inNew := configs[name]
+		switch {
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("%s %s removed", kind, name))
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("%s %s added: %+v", kind, name, to))
+		case fmt.Sprintf("%+v", from) != fmt.Sprintf("%+v", to):
+			changes = append(changes, fmt.Sprintf("%s %s changed: %+v -> %+v", kind, name, from, to))
+		}
+	}
+	return changes
+}
+
+func diffRoutes(old, routes []RouteRule) []string {
+	byID := func(routes []RouteRule) map[string]string {
+		m := make(map[string]string, len(routes))
+		for _, route := range routes {
+			m[route.ID] = route.describe()
+		}
+		return m
+	}
+	oldRoutes, newRoutes := byID(old), byID(routes)
+
+	var changes []string
+	for _, id := range sortedKeys(oldRoutes, newRoutes) {
+		from, inOld := oldRoutes[id]
+		to, inNew := newRoutes[id]
+		switch {
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("route %s removed", id))
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("route %s added: %s", id, to))
+		case from != to:
+			changes = append(changes, fmt.Sprintf("route %s changed: %s -> %s", id, from, to))
+		}
+	}
+
+	// The order decides which rule applies with the first precedence.
+	kept := func(routes []RouteRule, other map[string]string) []string {
+		var ids []string
+		for _, route := range routes {
+			if _, ok := other[route.ID]; ok {
+				ids = append(ids, route.ID)
+			}
+		}
+		return ids
+	}
+	if from, to := kept(old, newRoutes), kept(routes, oldRoutes); !slices.Equal(from, to) {
+		changes = append(changes, fmt.Sprintf("route order: %v -> %v", from, to))
+	}
+	return changes
+}
+
+func (r RouteRule) describe() string {
+	key := r.keySpec
+	if key == "" {
+		key = "ip"
+	}
+	return fmt.Sprintf("pattern=%q limit=%d window=%v block=%v algorithm=%s burst=%d prefix=/%d,/%d key=%s",
+		r.Pattern, r.Limit, r.Window, r.BlockDuration, r.Algorithm, r.Burst, r.IPPrefix.IPv4, r.IPPrefix.IPv6, key)
+}
+
+func sortedKeys[M ~map[string]V, V any](maps ...M) []string {
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	os.Clearenv()
+	path := writePolicyFile(t, "policy.yaml", testPolicyYAML)
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", path)
+	os.Setenv("REDIS_PASSWORD", "secret")
+	old, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = os.WriteFile(path, []byte(`ip:
+  limit: 30
+  block: 5m
+  window: 1m
+  prefix_v6: 48
+global:
+  limit: 1000
+  reserved: 100
+route_precedence: first
+plans:
+  pro:
+    limit: 100
+    window: 1m
+    block: 1h
+    priority: true
+    limits:
+      - limit: 10000
+        window: 1d
+tokens:
+  xyz789:
+    limit: 50
+routes:
+  - id: search
+    pattern: GET /search
+    limit: 100
+    key: header:API_KEY
+  - id: login
+    pattern: POST /login
+    limit: 10
+    window: 1m
+    block: 300
+`), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	os.Setenv("REDIS_PASSWORD", "other")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	changes := Diff(old, cfg)
+	for _, want := range []string{
+		"RedisPassword changed",
+		"IPLimit: 20 -> 30",
+		"token abc123 removed",
+		`route login changed: pattern="POST /login" limit=5 window=1m0s block=5m0s algorithm=fixed_window burst=0 prefix=/32,/48 key=ip -> pattern="POST /login" limit=10 window=1m0s block=5m0s algorithm=fixed_window burst=0 prefix=/32,/48 key=ip`,
+		"route order: [login search] -> [search login]",
+	} {
+		if !slices.Contains(changes, want) {
+			t.Errorf("expected change %q, got %q", want, changes)
+		}
+	}
+	if !slices.ContainsFunc(changes, func(change string) bool { return strings.HasPrefix(change, "token xyz789 added: ") }) {
+		t.Errorf("expected xyz789 to be reported as added, got %q", changes)
+	}
+	if len(changes) != 6 {
+		t.Errorf("expected 6 changes, got %d: %q", len(changes), changes)
+	}
+
+	if changes := Diff(cfg, cfg); len(changes) != 0 {
+		t.Errorf("expected no changes, got %q", changes)
+	}
+}
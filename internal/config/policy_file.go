@@ -0,0 +1,453 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
+	"gopkg.in/yaml.v3"
+)
+
+// policySettings maps the scalar fields of a policy file section to their env vars.
+var policySettings = map[string]map[string]string{
+	"ip": {
+		"limit":       "RATE_LIMIT_IP",
+		"block":       "RATE_LIMIT_IP_BLOCK_DURATION",
+		"algorithm":   "RATE_LIMIT_IP_ALGORITHM",
+		"window":      "RATE_LIMIT_IP_WINDOW",
+		"burst":       "RATE_LIMIT_IP_BURST",
+		"max_wait":    "RATE_LIMIT_IP_MAX_WAIT",
+		"prefix_v4":   "RATE_LIMIT_IP_PREFIX_V4",
+		"prefix_v6":   "RATE_LIMIT_IP_PREFIX_V6",
+		"concurrency": "RATE_LIMIT_IP_CONCURRENCY",
+	},
+	"global": {
+		"limit":    "RATE_LIMIT_GLOBAL",
+		"window":   "RATE_LIMIT_GLOBAL_WINDOW",
+		"reserved": "RATE_LIMIT_GLOBAL_RESERVED",
+		"status":   "RATE_LIMIT_GLOBAL_STATUS",
+	},
+}
+
+var yamlLineError = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// setting is a scalar config value along with where it was set.
+type setting struct {
+	value string
+	env   string
+	file  string
+	line  int
+	field string
+}
+
+func (s setting) invalid(err error) error {
+	if s.file != "" {
+		return fmt.Errorf("%s:%d: invalid %s: %w", s.file, s.line, s.field, err)
+	}
+	return fmt.Errorf("invalid %s: %w", s.env, err)
+}
+
+// policyFile holds the policies read from RATE_LIMIT_CONFIG_FILE; a nil one has none.
+type policyFile struct {
+	path     string
+	settings map[string]setting
+	tokens   map[string]limiter.TokenConfig
+	plans    map[string]limiter.TokenConfig
+	routes   []RouteRule
+	// tokenLines holds the line of each token.
+	tokenLines map[string]int
+}
+
+// setting returns the value of env, falling back to the policy file and defaultValue.
+func (p *policyFile) setting(env, defaultValue string) setting {
+	if value := getEnv(env, ""); value != "" {
+		return setting{value: value, env: env}
+	}
+	if p != nil {
+		if s, ok := p.settings[env]; ok {
+			return s
+		}
+	}
+	return setting{value: defaultValue, env: env}
+}
+
+// tokenConfigs returns a copy of the tokens of the file.
+func (p *policyFile) tokenConfigs() map[string]limiter.TokenConfig {
+	configs := make(map[string]limiter.TokenConfig)
+	if p != nil {
+		maps.Copy(configs, p.tokens)
+	}
+	return configs
+}
+
+// planConfigs returns a copy of the plans of the file.
+func (p *policyFile) planConfigs() map[string]limiter.TokenConfig {
+	configs := make(map[string]limiter.TokenConfig)
+	if p != nil {
+		maps.Copy(configs, p.plans)
+	}
+	return configs
+}
+
+// invalidToken reports err for a token of the file at the token's line.
+func (p *policyFile) invalidToken(token string, err error) error {
+	return fmt.Errorf("%s:%d: invalid tokens.%s: %w", p.path, p.tokenLines[token], token, err)
+}
+
+// checkTokenDigests checks that the tokens of the file are given by digest.
+func (p *policyFile) checkTokenDigests() error {
+	if p == nil {
+		return nil
+	}
+
+	line := 0
+	for token, tokenLine := range p.tokenLines {
+		if !middleware.IsTokenDigest(token) && (line == 0 || tokenLine < line) {
+			line = tokenLine
+		}
+	}
+	if line > 0 {
+		return fmt.Errorf("%s:%d: invalid token: not a digest (hash it with cmd/hashtoken)", p.path, line)
+	}
+	return nil
+}
+
+func (p *policyFile) routeRules() []RouteRule {
+	if p == nil {
+		return nil
+	}
+	return p.routes
+}
+
+// loadPolicyFile reads a YAML or JSON policy file; an empty path returns nil.
+func loadPolicyFile(path string) (*policyFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RATE_LIMIT_CONFIG_FILE: %w", err)
+	}
+	return parsePolicyFile(path, data)
+}
+
+func parsePolicyFile(path string, data []byte) (*policyFile, error) {
+	p := &policyFile{
+		path:       path,
+		settings:   make(map[string]setting),
+		tokens:     make(map[string]limiter.TokenConfig),
+		plans:      make(map[string]limiter.TokenConfig),
+		tokenLines: make(map[string]int),
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if m := yamlLineError.FindStringSubmatch(err.Error()); m != nil {
+			return nil, fmt.Errorf("%s:%s: %s", path, m[1], strings.TrimPrefix(err.Error(), m[0]))
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return p, nil
+	}
+
+	d := policyDecoder{path: path}
+	err := d.fields(doc.Content[0], "", func(key, value *yaml.Node) error {
+		switch key.Value {
+		case "ip", "global":
+			return d.settings(p, value, key.Value)
+		case "route_precedence":
+			s, err := d.scalar(value, key.Value)
+			if err != nil {
+				return err
+			}
+			p.settings["RATE_LIMIT_ROUTE_PRECEDENCE"] = d.setting(value, key.Value, s)
+			return nil
+		case "plans", "tokens":
+			return d.fields(value, key.Value, func(name, config *yaml.Node) error {
+				field := key.Value + "." + name.Value
+				_, isToken := p.tokens[name.Value]
+				_, isPlan := p.plans[name.Value]
+				if isToken || isPlan {
+					return d.invalid(name, field, errors.New("name already used by a token or plan"))
+				}
+				tokenConfig, err := d.tokenConfig(name, config, field)
+				if err != nil {
+					return err
+				}
+				if key.Value == "plans" {
+					if tokenConfig.Plan != "" {
+						return d.invalid(name, field, errors.New("a plan cannot use another plan"))
+					}
+					p.plans[name.Value] = tokenConfig
+					return nil
+				}
+				p.tokens[name.Value] = tokenConfig
+				p.tokenLines[name.Value] = name.Line
+				return nil
+			})
+		case "routes":
+			routes, err := d.routes(value)
+			if err != nil {
+				return err
+			}
+			p.routes = routes
+			return nil
+		default:
+			return d.invalid(key, key.Value, errors.New("unknown field"))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// policyDecoder walks a policy file, reporting errors with their file, line and path.
+type policyDecoder struct {
+	path string
+}
+
+func (d policyDecoder) invalid(node *yaml.Node, field string, err error) error {
+	return fmt.Errorf("%s:%d: invalid %s: %w", d.path, node.Line, field, err)
+}
+
+func (d policyDecoder) setting(node *yaml.Node, field, value string) setting {
+	return setting{value: value, file: d.path, line: node.Line, field: field}
+}
+
+// fields calls fn for every key of a mapping node, rejecting duplicate keys.
+func (d policyDecoder) fields(node *yaml.Node, field string, fn func(key, value *yaml.Node) error) error {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return d.invalid(node, fieldOrRoot(field), errors.New("expected a mapping"))
+	}
+
+	seen := make(map[string]bool, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if seen[key.Value] {
+			return d.invalid(key, joinField(field, key.Value), errors.New("duplicate field"))
+		}
+		seen[key.Value] = true
+
+		if err := fn(key, resolveAlias(node.Content[i+1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// items calls fn for every element of a sequence node.
+func (d policyDecoder) items(node *yaml.Node, field string, fn func(item *yaml.Node, field string) error) error {
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		return d.invalid(node, field, errors.New("expected a list"))
+	}
+	for i, item := range node.Content {
+		if err := fn(resolveAlias(item), fmt.Sprintf("%s[%d]", field, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d policyDecoder) scalar(node *yaml.Node, field string) (string, error) {
+	if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+		return "", d.invalid(node, field, errors.New("expected a value"))
+	}
+	return node.Value, nil
+}
+
+// settings records the scalar fields of the ip or global section as env var settings.
+func (d policyDecoder) settings(p *policyFile, node *yaml.Node, section string) error {
+	envs := policySettings[section]
+	return d.fields(node, section, func(key, value *yaml.Node) error {
+		field := section + "." + key.Value
+		env, ok := envs[key.Value]
+		if !ok {
+			return d.invalid(key, field, errors.New("unknown field"))
+		}
+		s, err := d.scalar(value, field)
+		if err != nil {
+			return err
+		}
+		p.settings[env] = d.setting(value, field, s)
+		return nil
+	})
+}
+
+// tokenConfig decodes the config of a token or plan, with the fields of RATE_LIMIT_TOKENS.
+func (d policyDecoder) tokenConfig(name, node *yaml.Node, field string) (limiter.TokenConfig, error) {
+	var config limiter.TokenConfig
+	var limits *yaml.Node
+
+	err := d.fields(node, field, func(key, value *yaml.Node) error {
+		f := field + "." + key.Value
+		if key.Value == "limits" {
+			limits = value
+			return nil
+		}
+
+		s, err := d.scalar(value, f)
+		if err != nil {
+			return err
+		}
+		switch key.Value {
+		case "limit":
+			config.Limit, err = parsePositive(s)
+		case "window":
+			config.Window, err = parseWindow(s)
+		case "block":
+			config.BlockDuration, err = parseDuration(s)
+		default:
+			err = setTokenOption(&config, key.Value, s)
+		}
+		if err != nil {
+			return d.invalid(value, f, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return limiter.TokenConfig{}, err
+	}
+	if config.Limit == 0 && config.Plan == "" {
+		return limiter.TokenConfig{}, d.invalid(name, field, errors.New("missing limit"))
+	}
+
+	if limits != nil {
+		err := d.items(limits, field+".limits", func(item *yaml.Node, field string) error {
+			limit, err := d.limit(item, field, config.BlockDuration)
+			if err != nil {
+				return err
+			}
+			config.Limits = append(config.Limits, limit)
+			return nil
+		})
+		if err != nil {
+			return limiter.TokenConfig{}, err
+		}
+	}
+
+	if config.Plan != "" {
+		return config, nil
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = limiter.FixedWindow
+	}
+	if err := validateCost(config); err != nil {
+		return limiter.TokenConfig{}, d.invalid(name, field, err)
+	}
+	if err := validateLimits(config); err != nil {
+		return limiter.TokenConfig{}, d.invalid(name, field, err)
+	}
+	return config, nil
+}
+
+// limit decodes one of the extra limits of a token config.
+func (d policyDecoder) limit(node *yaml.Node, field string, defaultBlock time.Duration) (limiter.Limit, error) {
+	l := limiter.Limit{BlockDuration: defaultBlock}
+	err := d.fields(node, field, func(key, value *yaml.Node) error {
+		f := field + "." + key.Value
+		s, err := d.scalar(value, f)
+		if err != nil {
+			return err
+		}
+		switch key.Value {
+		case "limit":
+			l.Limit, err = parsePositive(s)
+		case "window":
+			l.Window, err = parseWindow(s)
+		case "block":
+			l.BlockDuration, err = parseDuration(s)
+		default:
+			return d.invalid(key, f, errors.New("unknown field"))
+		}
+		if err != nil {
+			return d.invalid(value, f, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return limiter.Limit{}, err
+	}
+	if l.Limit == 0 {
+		return limiter.Limit{}, d.invalid(node, field, errors.New("missing limit"))
+	}
+	return l, nil
+}
+
+// routes decodes the route rules; conflicts are checked once RATE_LIMIT_ROUTES is merged in.
+func (d policyDecoder) routes(node *yaml.Node) ([]RouteRule, error) {
+	var routes []RouteRule
+	lines := make(map[string]int)
+
+	err := d.items(node, "routes", func(item *yaml.Node, field string) error {
+		route := RouteRule{Algorithm: limiter.FixedWindow}
+		err := d.fields(item, field, func(key, value *yaml.Node) error {
+			f := field + "." + key.Value
+			s, err := d.scalar(value, f)
+			if err != nil {
+				return err
+			}
+			if err := setRouteField(&route, key.Value, s); err != nil {
+				return d.invalid(value, f, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if route.Limit == 0 {
+			return d.invalid(item, field, errors.New("missing limit"))
+		}
+		if line, ok := lines[route.ID]; ok {
+			return d.invalid(item, field, fmt.Errorf("duplicate id %q (first used on line %d)", route.ID, line))
+		}
+		if err := validateRoutes([]RouteRule{route}, middleware.PrecedenceFirst); err != nil {
+			return d.invalid(item, field, err)
+		}
+		lines[route.ID] = item.Line
+		routes = append(routes, route)
+		return nil
+	})
+	return routes, err
+}
+
+func parsePositive(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q (expected a positive number)", s)
+	}
+	return n, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func joinField(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
+
+func fieldOrRoot(field string) string {
+	if field == "" {
+		return "document"
+	}
+	return field
+}
@@ -0,0 +1,380 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/middleware"
+)
+
+const testPolicyYAML = `ip:
+  limit: 20
+  block: 5m
+  window: 1m
+  prefix_v6: 48
+global:
+  limit: 1000
+  reserved: 100
+route_precedence: first
+plans:
+  pro:
+    limit: 100
+    window: 1m
+    block: 1h
+    priority: true
+    limits:
+      - limit: 10000
+        window: 1d
+tokens:
+  abc123:
+    limit: 10
+    block: 60
+    algorithm: token_bucket
+    burst: 20
+routes:
+  - id: login
+    pattern: POST /login
+    limit: 5
+    window: 1m
+    block: 300
+    prefix_v6: 48
+  - id: search
+    pattern: GET /search
+    limit: 100
+    key: header:API_KEY
+`
+
+func writePolicyFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_PolicyFileYAML(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", writePolicyFile(t, "policy.yaml", testPolicyYAML))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.IPLimit != 20 || cfg.IPBlockDuration != 5*time.Minute || cfg.IPWindow != time.Minute {
+		t.Errorf("expected IP limit 20/1m blocking for 5m, got %d/%v blocking for %v", cfg.IPLimit, cfg.IPWindow, cfg.IPBlockDuration)
+	}
+	if cfg.IPPrefix != (limiter.IPPrefix{IPv4: 32, IPv6: 48}) {
+		t.Errorf("expected IP prefix /32 and /48, got %+v", cfg.IPPrefix)
+	}
+	if cfg.GlobalLimit != 1000 || cfg.GlobalReserved != 100 {
+		t.Errorf("expected global limit 1000 with 100 reserved, got %d with %d", cfg.GlobalLimit, cfg.GlobalReserved)
+	}
+	if cfg.RoutePrecedence != middleware.PrecedenceFirst {
+		t.Errorf("expected route precedence first, got %s", cfg.RoutePrecedence)
+	}
+
+	pro := cfg.Plans["pro"]
+	if pro.Limit != 100 || pro.Window != time.Minute || pro.BlockDuration != time.Hour || !pro.Priority {
+		t.Errorf("unexpected pro plan config: %+v", pro)
+	}
+	if len(pro.Limits) != 1 || pro.Limits[0] != (limiter.Limit{Limit: 10000, Window: 24 * time.Hour, BlockDuration: time.Hour}) {
+		t.Errorf("expected the daily limit to inherit the plan block duration, got %+v", pro.Limits)
+	}
+
+	token := cfg.TokenConfigs["abc123"]
+	if token.Limit != 10 || token.BlockDuration != time.Minute || token.Algorithm != limiter.TokenBucket || token.Burst != 20 {
+		t.Errorf("unexpected abc123 config: %+v", token)
+	}
+
+	if len(cfg.Routes) != 2 || cfg.Routes[0].ID != "login" || cfg.Routes[0].BlockDuration != 300*time.Second || cfg.Routes[1].Key == nil {
+		t.Errorf("unexpected routes: %+v", cfg.Routes)
+	}
+	if cfg.Routes[0].IPPrefix != (limiter.IPPrefix{IPv4: 32, IPv6: 48}) {
+		t.Errorf("expected the login route to aggregate IPv6 by /48, got %+v", cfg.Routes[0].IPPrefix)
+	}
+}
+
+func TestLoad_PolicyFileJSON(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", writePolicyFile(t, "policy.json", `{
+  "ip": {"limit": 15, "algorithm": "sliding_window_log"},
+  "tokens": {
+    "abc123": {"limit": 50, "window": "1m", "block": "10m", "combine_ip": true}
+  },
+  "routes": [
+    {"id": "upload", "pattern": "POST /upload", "limit": 2}
+  ]
+}`))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.IPLimit != 15 || cfg.IPAlgorithm != limiter.SlidingWindowLog {
+		t.Errorf("expected IP limit 15 with sliding_window_log, got %d with %s", cfg.IPLimit, cfg.IPAlgorithm)
+	}
+	token := cfg.TokenConfigs["abc123"]
+	if token.Limit != 50 || token.Window != time.Minute || token.BlockDuration != 10*time.Minute || !token.CombineIP {
+		t.Errorf("unexpected abc123 config: %+v", token)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Pattern != "POST /upload" {
+		t.Errorf("unexpected routes: %+v", cfg.Routes)
+	}
+}
+
+func TestLoad_PolicyFileEnvOverrides(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", writePolicyFile(t, "policy.yaml", testPolicyYAML))
+	os.Setenv("RATE_LIMIT_IP", "50")
+	os.Setenv("RATE_LIMIT_TOKENS", "abc123:5:30,xyz789:7:30")
+	os.Setenv("RATE_LIMIT_ROUTES", "id=login;pattern=POST /signin;limit=3,id=export;pattern=GET /export;limit=1")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.IPLimit != 50 {
+		t.Errorf("expected RATE_LIMIT_IP to override the file, got %d", cfg.IPLimit)
+	}
+	if cfg.IPWindow != time.Minute {
+		t.Errorf("expected the file IP window to be kept, got %v", cfg.IPWindow)
+	}
+
+	if cfg.TokenConfigs["abc123"].Limit != 5 || cfg.TokenConfigs["xyz789"].Limit != 7 || cfg.Plans["pro"].Limit != 100 {
+		t.Errorf("expected env tokens to be merged over the file tokens, got %+v", cfg.TokenConfigs)
+	}
+
+	var ids []string
+	for _, route := range cfg.Routes {
+		ids = append(ids, route.ID+" "+route.Pattern)
+	}
+	if got := strings.Join(ids, ", "); got != "login POST /signin, search GET /search, export GET /export" {
+		t.Errorf("expected env routes to replace file routes by id, got %s", got)
+	}
+}
+
+func TestLoad_PolicyFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{
+			name:    "syntax",
+			file:    "policy.yaml",
+			content: "ip:\n  limit: 10\n   block: 5\n",
+			want:    "policy.yaml:3: ",
+		},
+		{
+			name:    "scalar",
+			file:    "policy.yaml",
+			content: "ip:\n  limit: 10\n  window: soon\n",
+			want:    "policy.yaml:3: invalid ip.window: ",
+		},
+		{
+			name:    "unknown section field",
+			file:    "policy.yaml",
+			content: "global:\n  limit: 10\n  color: red\n",
+			want:    "policy.yaml:3: invalid global.color: unknown field",
+		},
+		{
+			name:    "unknown top-level field",
+			file:    "policy.yaml",
+			content: "ip:\n  limit: 10\nplan: {}\n",
+			want:    "policy.yaml:3: invalid plan: unknown field",
+		},
+		{
+			name:    "token option",
+			file:    "policy.yaml",
+			content: "tokens:\n  abc123:\n    limit: 10\n    burst: -1\n",
+			want:    "policy.yaml:4: invalid tokens.abc123.burst: ",
+		},
+		{
+			name:    "token missing limit",
+			file:    "policy.yaml",
+			content: "tokens:\n  abc123:\n    block: 10\n",
+			want:    "policy.yaml:2: invalid tokens.abc123: missing limit",
+		},
+		{
+			name:    "token limits",
+			file:    "policy.yaml",
+			content: "tokens:\n  abc123:\n    limit: 10\n    limits:\n      - limit: 100\n        window: 1d\n      - limit: 0\n",
+			want:    "policy.yaml:7: invalid tokens.abc123.limits[1].limit: ",
+		},
+		{
+			name:    "duplicate windows",
+			file:    "policy.yaml",
+			content: "tokens:\n  abc123:\n    limit: 10\n    limits:\n      - limit: 100\n",
+			want:    "policy.yaml:2: invalid tokens.abc123: duplicate window",
+		},
+		{
+			name:    "duplicate token",
+			file:    "policy.yaml",
+			content: "tokens:\n  abc123:\n    limit: 10\n  abc123:\n    limit: 20\n",
+			want:    "policy.yaml:4: invalid tokens.abc123: duplicate field",
+		},
+		{
+			name:    "token named after plan",
+			file:    "policy.yaml",
+			content: "plans:\n  pro:\n    limit: 100\ntokens:\n  pro:\n    limit: 10\n",
+			want:    "policy.yaml:5: invalid tokens.pro: name already used by a token or plan",
+		},
+		{
+			name:    "route pattern",
+			file:    "policy.yaml",
+			content: "routes:\n  - id: a\n    pattern: /a\n    limit: 1\n  - id: b\n    pattern: /{bad\n    limit: 1\n",
+			want:    "policy.yaml:5: invalid routes[1]: ",
+		},
+		{
+			name:    "route prefix",
+			file:    "policy.yaml",
+			content: "routes:\n  - id: a\n    pattern: /a\n    limit: 1\n    prefix_v6: 200\n",
+			want:    "policy.yaml:5: invalid routes[0].prefix_v6: invalid prefix_v6 \"200\"",
+		},
+		{
+			name:    "duplicate route id",
+			file:    "policy.yaml",
+			content: "routes:\n  - id: a\n    pattern: /a\n    limit: 1\n  - id: a\n    pattern: /b\n    limit: 1\n",
+			want:    `policy.yaml:5: invalid routes[1]: duplicate id "a" (first used on line 2)`,
+		},
+		{
+			name:    "plan using plan",
+			file:    "policy.yaml",
+			content: "plans:\n  pro:\n    limit: 100\n  max:\n    plan: pro\n",
+			want:    "policy.yaml:4: invalid plans.max: a plan cannot use another plan",
+		},
+		{
+			name:    "unknown plan",
+			file:    "policy.yaml",
+			content: "plans:\n  pro:\n    limit: 100\ntokens:\n  abc123:\n    limit: 10\n  xyz789:\n    plan: gold\n",
+			want:    `policy.yaml:7: invalid tokens.xyz789: unknown plan "gold"`,
+		},
+		{
+			name:    "token override",
+			file:    "policy.yaml",
+			content: "plans:\n  pro:\n    limit: 100\n    limits:\n      - limit: 1000\n        window: 1m\ntokens:\n  abc123:\n    plan: pro\n    window: 1m\n",
+			want:    "policy.yaml:8: invalid tokens.abc123: duplicate window",
+		},
+		{
+			name:    "json",
+			file:    "policy.json",
+			content: "{\n  \"tokens\": {\n    \"abc123\": {\"limit\": \"many\"}\n  }\n}\n",
+			want:    "policy.json:3: invalid tokens.abc123.limit: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			path := writePolicyFile(t, tt.file, tt.content)
+			os.Setenv("RATE_LIMIT_CONFIG_FILE", path)
+
+			_, err := Load()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			want := filepath.Join(filepath.Dir(path), tt.want)
+			if !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("expected error starting with %q, got %q", want, err)
+			}
+		})
+	}
+}
+
+func TestLoad_PolicyFileMissing(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for a missing RATE_LIMIT_CONFIG_FILE")
+	}
+}
+
+func TestLoad_PolicyFileConflictingRoutes(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", writePolicyFile(t, "policy.yaml", "routes:\n  - id: a\n    pattern: /{x}/b\n    limit: 1\n"))
+	os.Setenv("RATE_LIMIT_ROUTES", "id=b;pattern=/a/{y};limit=1")
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for conflicting file and env routes")
+	}
+}
+
+func TestLoad_PolicyFileTokenDigests(t *testing.T) {
+	digest := middleware.SHA256Token("abc123")
+
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_TOKEN_HASH", "sha256")
+	path := writePolicyFile(t, "policy.yaml", "plans:\n  pro:\n    limit: 100\ntokens:\n  "+digest+":\n    limit: 10\n")
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", path)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TokenConfigs[digest].Limit != 10 || cfg.Plans["pro"].Limit != 100 {
+		t.Errorf("expected plans to keep their names and tokens to be given by digest, got %+v", cfg.TokenConfigs)
+	}
+
+	path = writePolicyFile(t, "policy.yaml", "tokens:\n  "+digest+":\n    limit: 10\n  xyz789:\n    limit: 10\n  abc123:\n    limit: 10\n")
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", path)
+
+	_, err = Load()
+	if err == nil {
+		t.Fatal("expected error for tokens not given by digest")
+	}
+	if want := path + ":4: invalid token: not a digest"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error starting with %q, got %q", want, err)
+	}
+}
+
+func TestLoad_PolicyFilePlans(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("RATE_LIMIT_CONFIG_FILE", writePolicyFile(t, "policy.yaml", `plans:
+  free:
+    limit: 10
+    block: 1m
+  pro:
+    limit: 100
+    window: 1m
+    limits:
+      - limit: 10000
+        window: 1d
+tokens:
+  abc123:
+    plan: pro
+  xyz789:
+    plan: pro
+    limit: 200
+    concurrency: 5
+`))
+	os.Setenv("RATE_LIMIT_PLANS", "free:20:60")
+	os.Setenv("RATE_LIMIT_TOKENS", "def456:plan=free")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.Plans["free"].Limit != 20 || cfg.Plans["pro"].Limit != 100 {
+		t.Errorf("expected env plans to be merged over the file plans, got %+v", cfg.Plans)
+	}
+	if token := cfg.TokenConfigs["abc123"]; token.Plan != "pro" || token.Limit != 0 || token.Algorithm != "" {
+		t.Errorf("expected abc123 to only reference the pro plan, got %+v", token)
+	}
+	if token := cfg.TokenConfigs["xyz789"]; token.Plan != "pro" || token.Limit != 200 || token.Concurrency != 5 || token.Algorithm != "" {
+		t.Errorf("expected xyz789 to override the pro plan, got %+v", token)
+	}
+	if token := cfg.TokenConfigs["def456"]; token.Plan != "free" {
+		t.Errorf("expected def456 to reference the free plan, got %+v", token)
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces the events of a single save.
+const watchDebounce = 100 * time.Millisecond
+
+// Watch calls reload whenever the policy file at path changes, until ctx is done.
+func Watch(ctx context.Context, path string, reload func(), onError func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	name := filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(name)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == name && !event.Has(fsnotify.Chmod) {
+					debounce = time.After(watchDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			case <-debounce:
+				debounce = nil
+				reload()
+			}
+		}
+	}()
+
+	return nil
+}
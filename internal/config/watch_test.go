@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(path, []byte("ip:\n  limit: 10\n"), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{}, 10)
+	err := Watch(ctx, path, func() { reloads <- struct{}{} }, func(err error) { t.Errorf("unexpected watcher error: %v", err) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectReload := func(what string) {
+		t.Helper()
+		select {
+		case <-reloads:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected a reload after %s", what)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("ip: {}\n"), 0o600); err != nil {
+		t.Fatalf("failed to write other file: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("ip:\n  limit: 20\n"), 0o600); err != nil {
+		t.Fatalf("failed to write policy file: %v", err)
+	}
+	expectReload("a write")
+
+	// Editors and deployments often replace the file through a rename.
+	tmp := filepath.Join(dir, ".policy.yaml.tmp")
+	if err := os.WriteFile(tmp, []byte("ip:\n  limit: 30\n"), 0o600); err != nil {
+		t.Fatalf("failed to write temporary file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("failed to replace policy file: %v", err)
+	}
+	expectReload("a rename")
+
+	select {
+	case <-reloads:
+		t.Error("expected changes to other files to be ignored and bursts coalesced")
+	case <-time.After(3 * watchDebounce):
+	}
+}
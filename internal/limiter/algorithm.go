@@ -0,0 +1,35 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Algorithm selects how requests are counted against a rule's limit.
+type Algorithm string
+
+const (
+	// FixedWindow counts requests in per-second buckets.
+	FixedWindow Algorithm = "fixed_window"
+	// SlidingWindowLog counts the accepted requests within the trailing window.
+	SlidingWindowLog Algorithm = "sliding_window_log"
+	// SlidingWindowCounter weights the previous window's count by its overlap with the trailing window.
+	SlidingWindowCounter Algorithm = "sliding_window_counter"
+	// TokenBucket refills Limit tokens per Window up to Burst and never blocks keys.
+	TokenBucket Algorithm = "token_bucket"
+	// GCRA is the generic cell rate algorithm and never blocks keys.
+	GCRA Algorithm = "gcra"
+	// LeakyBucket delays requests to one every Window/Limit instead of rejecting them.
+	LeakyBucket Algorithm = "leaky_bucket"
+)
+
+var ErrUnsupportedAlgorithm = errors.New("algorithm not supported by store")
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch a := Algorithm(s); a {
+	case FixedWindow, SlidingWindowLog, SlidingWindowCounter, TokenBucket, GCRA, LeakyBucket:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown algorithm: %s", s)
+	}
+}
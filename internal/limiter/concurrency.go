@@ -0,0 +1,150 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultLeaseTTL = 30 * time.Second
+
+// ConcurrencyStore tracks in-flight requests per key as leases that expire unless extended.
+type ConcurrencyStore interface {
+	Acquire(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (acquired bool, inFlight int64, err error)
+	Extend(ctx context.Context, key string, leaseID string, ttl time.Duration) error
+	Release(ctx context.Context, key string, leaseID string) error
+}
+
+// ConcurrencyLimiter limits the number of requests in flight at the same time.
+type ConcurrencyLimiter struct {
+	store    ConcurrencyStore
+	ipLimit  int
+	tokens   TokenProvider
+	plans    TokenProvider
+	perToken bool
+	leaseTTL time.Duration
+	ipPrefix IPPrefix
+}
+
+type ConcurrencyOption func(*ConcurrencyLimiter)
+
+func WithConcurrencyIPPrefix(prefix IPPrefix) ConcurrencyOption {
+	return func(cl *ConcurrencyLimiter) {
+		cl.ipPrefix = prefix
+	}
+}
+
+// WithConcurrencyTokenProvider looks token configs up in provider instead of tokenConfigs.
+func WithConcurrencyTokenProvider(provider TokenProvider) ConcurrencyOption {
+	return func(cl *ConcurrencyLimiter) {
+		cl.tokens = provider
+	}
+}
+
+// WithConcurrencyPlanProvider looks up the plans named by Identity.Plan in provider.
+func WithConcurrencyPlanProvider(provider TokenProvider) ConcurrencyOption {
+	return func(cl *ConcurrencyLimiter) {
+		cl.plans = provider
+	}
+}
+
+// WithConcurrencyPerTokenLimit limits every token without a config with the IP limit.
+func WithConcurrencyPerTokenLimit() ConcurrencyOption {
+	return func(cl *ConcurrencyLimiter) {
+		cl.perToken = true
+	}
+}
+
+func NewConcurrencyLimiter(store ConcurrencyStore, ipLimit int, tokenConfigs map[string]TokenConfig, leaseTTL time.Duration, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	if leaseTTL <= 0 {
+		leaseTTL = defaultLeaseTTL
+	}
+	cl := &ConcurrencyLimiter{
+		store:    store,
+		ipLimit:  ipLimit,
+		tokens:   StaticTokens(tokenConfigs),
+		leaseTTL: leaseTTL,
+	}
+	for _, opt := range opts {
+		opt(cl)
+	}
+	return cl
+}
+
+// Acquire takes an in-flight slot; release must be called once an allowed request is served.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, id Identity) (Decision, func(), error) {
+	decision := Decision{
+		Key:     "ip:" + cl.ipPrefix.Key(id.IP),
+		Rule:    RuleIP,
+		Limit:   cl.ipLimit,
+		Allowed: true,
+	}
+	if id.Token != "" {
+		config, exists, err := lookupIdentity(ctx, cl.tokens, cl.plans, id)
+		if err != nil {
+			return Decision{}, nil, err
+		}
+		if exists || cl.perToken {
+			decision.Key = "token:" + id.Token
+			decision.Rule = RuleToken
+			decision.Plan = config.Plan
+		}
+		if exists {
+			decision.Limit = config.Concurrency
+		}
+	}
+
+	if decision.Limit <= 0 {
+		return decision, func() {}, nil
+	}
+
+	leaseID := rand.Text()
+	acquired, inFlight, err := cl.store.Acquire(ctx, decision.Key, leaseID, decision.Limit, cl.leaseTTL)
+	if err != nil {
+		return Decision{}, nil, err
+	}
+
+	decision.Allowed = acquired
+	decision.Remaining = max(0, decision.Limit-int(inFlight))
+	if !acquired {
+		return decision, func() {}, nil
+	}
+
+	return decision, cl.keepAlive(context.WithoutCancel(ctx), decision.Key, leaseID), nil
+}
+
+func (cl *ConcurrencyLimiter) keepAlive(ctx context.Context, key string, leaseID string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(cl.leaseTTL / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := cl.store.Extend(ctx, key, leaseID, cl.leaseTTL); err != nil {
+					log.Printf("failed to extend concurrency lease for %s: %v", key, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			if err := cl.store.Release(ctx, key, leaseID); err != nil {
+				log.Printf("failed to release concurrency lease for %s: %v", key, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_LimitsInFlightRequests(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	cl := NewConcurrencyLimiter(store, 2, nil, time.Minute)
+	ctx := context.Background()
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		decision, release, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("request %d: expected slot to be acquired", i+1)
+		}
+		if want := 1 - i; decision.Remaining != want {
+			t.Errorf("request %d: expected %d slots remaining, got %d", i+1, want, decision.Remaining)
+		}
+		releases = append(releases, release)
+	}
+
+	decision, _, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected third concurrent request to be rejected")
+	}
+
+	decision, release, err := cl.Acquire(ctx, Identity{IP: "192.168.1.2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected a different IP to get its own slots")
+	}
+	release()
+
+	releases[0]()
+	releases[0]()
+
+	decision, _, err = cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected slot to be available after release")
+	}
+}
+
+func TestConcurrencyLimiter_ExpiredLeaseIsReclaimed(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	cl := NewConcurrencyLimiter(store, 1, nil, time.Hour)
+	ctx := context.Background()
+
+	if _, _, err := store.Acquire(ctx, "ip:192.168.1.1", "crashed", 1, 30*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decision, _, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected slot held by the crashed instance to be in use")
+	}
+
+	clock.Advance(31 * time.Second)
+	decision, release, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected expired lease to be reclaimed")
+	}
+	release()
+}
+
+func TestConcurrencyLimiter_LeaseIsKeptAliveWhileInFlight(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	store.now = time.Now
+	cl := NewConcurrencyLimiter(store, 1, nil, 60*time.Millisecond)
+	ctx := context.Background()
+
+	decision, release, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Fatal("expected slot to be acquired")
+	}
+	defer release()
+
+	time.Sleep(150 * time.Millisecond)
+
+	decision, _, err = cl.Acquire(ctx, Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected long running request to keep its lease")
+	}
+}
+
+func TestConcurrencyLimiter_TokenConfig(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	tokenConfigs := map[string]TokenConfig{
+		"slow":      {Limit: 100, Concurrency: 1},
+		"unlimited": {Limit: 100},
+	}
+	cl := NewConcurrencyLimiter(store, 5, tokenConfigs, time.Minute)
+	ctx := context.Background()
+
+	decision, release, err := cl.Acquire(ctx, Identity{IP: "192.168.1.1", Token: "slow"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+	if decision.Key != "token:slow" || decision.Limit != 1 {
+		t.Errorf("expected token key with limit 1, got %s with limit %d", decision.Key, decision.Limit)
+	}
+
+	decision, _, err = cl.Acquire(ctx, Identity{IP: "192.168.1.2", Token: "slow"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected token limit to apply across IPs")
+	}
+
+	for i := 0; i < 10; i++ {
+		decision, _, err = cl.Acquire(ctx, Identity{IP: "192.168.1.1", Token: "unlimited"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatal("expected token without concurrency limit to be unlimited")
+		}
+	}
+}
+
+func TestConcurrencyLimiter_DisabledForIP(t *testing.T) {
+	cl := NewConcurrencyLimiter(nil, 0, nil, time.Minute)
+
+	decision, release, err := cl.Acquire(context.Background(), Identity{IP: "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected request to be allowed when the limit is disabled")
+	}
+	release()
+}
+
+func TestConcurrencyLimiter_IPPrefix(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	cl := NewConcurrencyLimiter(store, 1, nil, time.Minute, WithConcurrencyIPPrefix(IPPrefix{IPv4: 24, IPv6: 64}))
+	ctx := context.Background()
+
+	decision, release, err := cl.Acquire(ctx, Identity{IP: "10.1.2.3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+	if decision.Key != "ip:10.1.2.0/24" {
+		t.Errorf("expected key ip:10.1.2.0/24, got %s", decision.Key)
+	}
+
+	decision, _, err = cl.Acquire(ctx, Identity{IP: "::ffff:10.1.2.200"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected another address of the same /24 to share the slot")
+	}
+}
+
+func TestConcurrencyLimiter_IdentityPlan(t *testing.T) {
+	store, _ := newTestMemoryStore(t, 0)
+	plans := StaticTokens{"pro": {Limit: 100, Concurrency: 1}}
+	cl := NewConcurrencyLimiter(store, 5, nil, time.Minute,
+		WithConcurrencyPlanProvider(plans),
+		WithConcurrencyPerTokenLimit(),
+	)
+	ctx := context.Background()
+
+	id := Identity{IP: "192.168.1.1", Token: "jwt:user-1", Plan: "pro"}
+	decision, release, err := cl.Acquire(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+	if !decision.Allowed || decision.Key != "token:jwt:user-1" || decision.Limit != 1 || decision.Plan != "pro" {
+		t.Errorf("expected the plan's limit of 1 for the claim, got %+v", decision)
+	}
+
+	decision, _, err = cl.Acquire(ctx, Identity{IP: "192.168.1.2", Token: "jwt:user-1", Plan: "pro"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected the plan's concurrency limit to apply to the claim")
+	}
+
+	// A claim without a plan gets the IP limit on its own key.
+	decision, release, err = cl.Acquire(ctx, Identity{IP: "192.168.1.1", Token: "jwt:user-2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer release()
+	if decision.Key != "token:jwt:user-2" || decision.Limit != 5 {
+		t.Errorf("expected the IP limit of 5 for the claim, got %+v", decision)
+	}
+}
@@ -0,0 +1,20 @@
+package limiter
+
+import "time"
+
+// Decision describes the outcome of a rate limit check for a single request.
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Window     time.Duration
+	ResetAt    time.Time
+	RetryAfter time.Duration
+	Key        string
+	Rule       string
+	// Plan names the plan the client's token config comes from, if any.
+	Plan string
+
+	// Delay is how long the leaky bucket holds an allowed request before it is served.
+	Delay time.Duration
+}
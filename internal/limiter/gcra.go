@@ -0,0 +1,32 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+func (rl *RateLimiter) checkGCRA(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	gcraStore, ok := rl.store.(GCRAStore)
+	if !ok {
+		return Decision{}, unsupported(r.algorithm)
+	}
+
+	if r.limit <= 0 {
+		return r.decision(), nil
+	}
+
+	interval := emissionInterval(r)
+	tolerance := interval * time.Duration(r.burst)
+	allowed, retryAfter, resetAfter, err := gcraStore.GCRA(ctx, r.key, interval, tolerance, r.cost)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := r.decision()
+	decision.Allowed = allowed
+	decision.Limit = r.burst
+	decision.Remaining = max(0, int((tolerance-resetAfter)/interval))
+	decision.ResetAt = now.Add(resetAfter)
+	decision.RetryAfter = retryAfter
+	return decision, nil
+}
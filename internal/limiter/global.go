@@ -0,0 +1,103 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	RuleGlobal = "global"
+
+	globalKey = "global"
+)
+
+// GlobalLimiter caps the requests served across all clients.
+type GlobalLimiter struct {
+	limit    int
+	window   time.Duration
+	reserved int
+	tokens   TokenProvider
+	plans    TokenProvider
+}
+
+type GlobalOption func(*GlobalLimiter)
+
+// WithGlobalWindow sets the fixed window the limit applies to.
+func WithGlobalWindow(window time.Duration) GlobalOption {
+	return func(g *GlobalLimiter) {
+		g.window = window
+	}
+}
+
+// WithGlobalReserved reserves part of every window's limit for priority tokens.
+func WithGlobalReserved(reserved int) GlobalOption {
+	return func(g *GlobalLimiter) {
+		g.reserved = reserved
+	}
+}
+
+// WithGlobalTokenProvider looks token configs up in provider instead of tokenConfigs.
+func WithGlobalTokenProvider(provider TokenProvider) GlobalOption {
+	return func(g *GlobalLimiter) {
+		g.tokens = provider
+	}
+}
+
+// WithGlobalPlanProvider looks up the plans named by Identity.Plan in provider.
+func WithGlobalPlanProvider(provider TokenProvider) GlobalOption {
+	return func(g *GlobalLimiter) {
+		g.plans = provider
+	}
+}
+
+func NewGlobalLimiter(limit int, tokenConfigs map[string]TokenConfig, opts ...GlobalOption) *GlobalLimiter {
+	g := &GlobalLimiter{
+		limit:  limit,
+		window: defaultWindow,
+		tokens: StaticTokens(tokenConfigs),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.window = max(g.window.Truncate(time.Second), time.Second)
+	return g
+}
+
+// limitFor returns the share of the limit available to id.
+func (g *GlobalLimiter) limitFor(ctx context.Context, id Identity) (int, error) {
+	priority, err := g.priority(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if priority {
+		return g.limit, nil
+	}
+	return g.limit - g.reserved, nil
+}
+
+// rule returns the global limit applying to id as a fixed window rule.
+func (g *GlobalLimiter) rule(ctx context.Context, id Identity) (rule, error) {
+	limit, err := g.limitFor(ctx, id)
+	if err != nil {
+		return rule{}, err
+	}
+
+	return rule{
+		id:        RuleGlobal,
+		key:       globalKey,
+		limit:     limit,
+		algorithm: FixedWindow,
+		window:    g.window,
+		burst:     limit,
+		cost:      1,
+	}, nil
+}
+
+func (g *GlobalLimiter) priority(ctx context.Context, id Identity) (bool, error) {
+	if id.Token == "" && id.Plan == "" {
+		return false, nil
+	}
+
+	config, _, err := lookupIdentity(ctx, g.tokens, g.plans, id)
+	return config.Priority, err
+}
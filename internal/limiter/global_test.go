@@ -0,0 +1,154 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_GlobalReservesShareForPriorityTokens(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	tokenConfigs := map[string]TokenConfig{
+		"premium": {Limit: 100, Priority: true},
+		"basic":   {Limit: 100},
+	}
+	g := NewGlobalLimiter(5, tokenConfigs, WithGlobalReserved(2))
+	rl := NewRateLimiter(store, 100, 0, tokenConfigs, WithGlobalLimiter(g))
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	check := func(id Identity) Decision {
+		t.Helper()
+		decision, err := rl.CheckIdentity(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return decision
+	}
+
+	for i := 0; i < 3; i++ {
+		if decision := check(Identity{IP: "10.0.0.1", Token: "basic"}); !decision.Allowed {
+			t.Fatalf("request %d: expected request to be allowed", i+1)
+		}
+	}
+
+	decision := check(Identity{IP: "10.0.0.2"})
+	if decision.Allowed || decision.Rule != RuleGlobal || decision.RetryAfter != time.Second {
+		t.Errorf("expected the global limit to reject ordinary requests, got %+v", decision)
+	}
+
+	// Rejected requests do not use up the reserved share.
+	for i := 0; i < 10; i++ {
+		check(Identity{IP: "10.0.0.1", Token: "basic"})
+	}
+
+	for i := 0; i < 2; i++ {
+		if decision := check(Identity{IP: "10.0.0.3", Token: "premium"}); !decision.Allowed {
+			t.Fatalf("priority request %d: expected request to be allowed", i+1)
+		}
+	}
+	if decision := check(Identity{IP: "10.0.0.3", Token: "premium"}); decision.Allowed || decision.Rule != RuleGlobal {
+		t.Errorf("expected priority requests to be rejected once the whole limit is used, got %+v", decision)
+	}
+
+	clock.Advance(time.Second)
+	if decision := check(Identity{IP: "10.0.0.2"}); !decision.Allowed {
+		t.Errorf("expected the limit to reset in the next window, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_GlobalPriorityPlan(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	plans := map[string]TokenConfig{"enterprise": {Limit: 100, Priority: true}}
+	g := NewGlobalLimiter(1, plans, WithGlobalReserved(1))
+	rl := NewRateLimiter(store, 100, 0, plans, WithGlobalLimiter(g))
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	decision, err := rl.CheckIdentity(ctx, Identity{IP: "10.0.0.1", Token: "user-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed || decision.Rule != RuleGlobal {
+		t.Errorf("expected tokens without priority to be rejected when the whole limit is reserved, got %+v", decision)
+	}
+
+	decision, err = rl.CheckIdentity(ctx, Identity{IP: "10.0.0.1", Token: "user-1", Plan: "enterprise"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Errorf("expected a priority plan to use the reserved share, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_GlobalLimiterOtherAlgorithms(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	g := NewGlobalLimiter(2, nil, WithGlobalWindow(time.Minute))
+	rl := NewRateLimiter(store, 100, 0, nil, WithIPAlgorithm(TokenBucket), WithGlobalLimiter(g))
+	rl.now = clock.Now
+	ctx := context.Background()
+	clock.Advance(15 * time.Second)
+
+	for i := 0; i < 2; i++ {
+		decision, err := rl.CheckIdentity(ctx, Identity{IP: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed || decision.Rule != RuleIP {
+			t.Fatalf("request %d: expected the ip rule to allow, got %+v", i+1, decision)
+		}
+	}
+
+	decision, err := rl.CheckIdentity(ctx, Identity{IP: "10.0.0.2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed || decision.Rule != RuleGlobal || decision.Window != time.Minute || decision.RetryAfter != 45*time.Second {
+		t.Errorf("expected the third request of the minute to be rejected for 45s, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_GlobalLimiter(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	g := NewGlobalLimiter(3, nil, WithGlobalWindow(time.Minute))
+	rl := NewRateLimiter(store, 2, 0, nil, WithIPWindow(time.Hour), WithGlobalLimiter(g))
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	check := func(ip string) Decision {
+		t.Helper()
+		decision, err := rl.CheckIdentity(ctx, Identity{IP: ip})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return decision
+	}
+
+	// Allowed requests report the client limit; requests over it are
+	// rejected by it without using the global limit.
+	if decision := check("10.0.0.1"); !decision.Allowed || decision.Rule != RuleIP || decision.Remaining != 1 {
+		t.Errorf("expected the ip limit with 1 remaining, got %+v", decision)
+	}
+	check("10.0.0.1")
+	for i := 0; i < 3; i++ {
+		if decision := check("10.0.0.1"); decision.Allowed || decision.Rule != RuleIP {
+			t.Fatalf("expected the ip limit to reject, got %+v", decision)
+		}
+	}
+
+	if decision := check("10.0.0.2"); !decision.Allowed {
+		t.Fatalf("expected the last request of the global limit to be allowed, got %+v", decision)
+	}
+	if decision := check("10.0.0.3"); decision.Allowed || decision.Rule != RuleGlobal {
+		t.Errorf("expected the global limit to reject, got %+v", decision)
+	}
+	if decision := check("10.0.0.2"); decision.Allowed || decision.Rule != RuleGlobal {
+		t.Errorf("expected the global limit to reject, got %+v", decision)
+	}
+
+	clock.Advance(time.Minute)
+	if decision := check("10.0.0.2"); !decision.Allowed || decision.Remaining != 0 {
+		t.Errorf("expected the global rejection not to count against the client, got %+v", decision)
+	}
+}
@@ -0,0 +1,32 @@
+package limiter
+
+import "net/netip"
+
+// IPPrefix aggregates client addresses into networks, e.g. {IPv4: 32, IPv6: 64}.
+type IPPrefix struct {
+	IPv4 int
+	IPv6 int
+}
+
+// Key returns ip masked to the prefix; values that are not IP addresses are returned as is.
+func (p IPPrefix) Key(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+
+	bits := p.IPv6
+	if addr.Is4() {
+		bits = p.IPv4
+	}
+	if bits <= 0 || bits >= addr.BitLen() {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIPPrefix_Key(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix IPPrefix
+		ip     string
+		want   string
+	}{
+		{"no prefix keeps ipv4", IPPrefix{}, "192.168.1.1", "192.168.1.1"},
+		{"no prefix keeps ipv6", IPPrefix{}, "2001:db8::1", "2001:db8::1"},
+		{"ipv4 full length", IPPrefix{IPv4: 32, IPv6: 64}, "192.168.1.1", "192.168.1.1"},
+		{"ipv4 /24", IPPrefix{IPv4: 24, IPv6: 64}, "192.168.1.77", "192.168.1.0/24"},
+		{"ipv6 /64", IPPrefix{IPv4: 32, IPv6: 64}, "2001:db8:1:2:aaaa:bbbb:cccc:dddd", "2001:db8:1:2::/64"},
+		{"ipv6 /48", IPPrefix{IPv4: 32, IPv6: 48}, "2001:db8:1:2::1", "2001:db8:1::/48"},
+		{"ipv6 full length", IPPrefix{IPv4: 32, IPv6: 128}, "2001:db8::1", "2001:db8::1"},
+		{"ipv6 non canonical form", IPPrefix{}, "2001:DB8:0:0::0001", "2001:db8::1"},
+		{"ipv6 zone dropped", IPPrefix{IPv6: 64}, "fe80::1%eth0", "fe80::/64"},
+		{"ipv4 mapped uses ipv4 prefix", IPPrefix{IPv4: 24, IPv6: 64}, "::ffff:192.168.1.77", "192.168.1.0/24"},
+		{"ipv4 mapped without prefix", IPPrefix{}, "::ffff:10.0.0.1", "10.0.0.1"},
+		{"not an address", IPPrefix{IPv4: 24, IPv6: 64}, "unix-socket", "unix-socket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prefix.Key(tt.ip); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_IPPrefixSharesLimitAcrossNetwork(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 2, time.Minute, nil, WithIPPrefix(IPPrefix{IPv4: 32, IPv6: 64}))
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	for _, ip := range []string{"2001:db8::1", "2001:db8::2"} {
+		decision, err := rl.Check(ctx, ip, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Errorf("expected request from %s to be allowed", ip)
+		}
+		if decision.Key != "ip:2001:db8::/64" {
+			t.Errorf("expected key ip:2001:db8::/64, got %s", decision.Key)
+		}
+	}
+
+	decision, err := rl.Check(ctx, "2001:db8::ffff:3", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected third address in the same /64 to be rejected")
+	}
+
+	decision, err = rl.Check(ctx, "2001:db8:0:1::1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected address in another /64 to be allowed")
+	}
+
+	decision, err = rl.Check(ctx, "::ffff:192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Key != "ip:192.168.1.1" {
+		t.Errorf("expected ipv4 mapped address to share the ipv4 key, got %s", decision.Key)
+	}
+}
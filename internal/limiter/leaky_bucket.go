@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// checkLeakyBucket schedules the request on a GCRA whose tolerance is the longest wait.
+func (rl *RateLimiter) checkLeakyBucket(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	gcraStore, ok := rl.store.(GCRAStore)
+	if !ok {
+		return Decision{}, unsupported(r.algorithm)
+	}
+
+	if r.limit <= 0 {
+		return r.decision(), nil
+	}
+
+	interval := emissionInterval(r)
+	maxDelay := interval * time.Duration(r.burst)
+	if r.maxWait > 0 && r.maxWait < maxDelay {
+		maxDelay = r.maxWait
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < maxDelay {
+		maxDelay = max(0, deadline.Sub(now))
+	}
+
+	cost := interval * time.Duration(r.cost)
+	allowed, retryAfter, resetAfter, err := gcraStore.GCRA(ctx, r.key, interval, cost+maxDelay, r.cost)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := r.decision()
+	decision.Allowed = allowed
+	decision.Limit = r.burst
+	decision.RetryAfter = retryAfter
+	decision.ResetAt = now.Add(resetAfter)
+	if allowed {
+		decision.Delay = max(0, resetAfter-cost)
+		decision.Remaining = max(0, int((maxDelay-decision.Delay)/interval))
+	}
+	return decision, nil
+}
+
+// refundLeakyBucket gives back the slot checkLeakyBucket scheduled for a request.
+func (rl *RateLimiter) refundLeakyBucket(ctx context.Context, r rule) error {
+	gcraStore, ok := rl.store.(GCRAStore)
+	if !ok || r.limit <= 0 {
+		return nil
+	}
+
+	interval := emissionInterval(r)
+	_, _, _, err := gcraStore.GCRA(ctx, r.key, interval, interval*time.Duration(r.burst), -r.cost)
+	return err
+}
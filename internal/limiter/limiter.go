@@ -2,73 +2,671 @@ package limiter
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 )
 
+const (
+	RuleIP    = "ip"
+	RuleToken = "token"
+	RulePair  = "pair"
+)
+
+const defaultWindow = time.Second
+
 type TokenConfig struct {
 	Limit         int
 	BlockDuration time.Duration
+	Algorithm     Algorithm
+	// Window is the period Limit applies to; fixed windows are truncated to whole seconds.
+	Window time.Duration
+	// Burst is the token bucket capacity or GCRA burst size; it defaults to Limit.
+	Burst int
+	// Cost is the number of tokens each request takes; it defaults to 1.
+	Cost int
+	// MaxWait bounds how long the leaky bucket may hold a request; zero means no bound.
+	MaxWait time.Duration
+	// Concurrency is the maximum number of in-flight requests; zero means unlimited.
+	Concurrency int
+	// Limits are further fixed window limits enforced together with Limit, each with its own window.
+	Limits []Limit
+	// CombineIP enforces the IP rule as well as the token's limit.
+	CombineIP bool
+	// PairLimit, when positive, also limits each token and IP pair.
+	PairLimit int
+	// Priority lets the token use the share of the global limit reserved for priority traffic.
+	Priority bool
+	// Plan names the plan the token's settings come from, see ResolvePlans.
+	Plan string
+}
+
+// Limit is one of the limits of a multi-limit policy.
+type Limit struct {
+	Limit         int
+	Window        time.Duration
+	BlockDuration time.Duration
+}
+
+// Identity is the client a request is limited for.
+type Identity struct {
+	IP    string
+	Token string
+	// Plan names a plan applied to Token instead of its own config, e.g. from a JWT claim.
+	Plan string
+	// Limit, when positive, overrides the limit applied to Token.
+	Limit int
 }
 
 type RateLimiter struct {
 	store           Store
 	ipLimit         int
 	ipBlockDuration time.Duration
-	tokenConfigs    map[string]TokenConfig
+	ipAlgorithm     Algorithm
+	ipWindow        time.Duration
+	ipBurst         int
+	ipMaxWait       time.Duration
+	ipPrefix        IPPrefix
+	tokens          TokenProvider
+	plans           TokenProvider
+	ruleID          string
+	perToken        bool
+	tokenIPLimit    bool
+	global          *GlobalLimiter
+	now             func() time.Time
+}
+
+type Option func(*RateLimiter)
+
+func WithIPAlgorithm(algorithm Algorithm) Option {
+	return func(rl *RateLimiter) {
+		rl.ipAlgorithm = algorithm
+	}
+}
+
+func WithIPWindow(window time.Duration) Option {
+	return func(rl *RateLimiter) {
+		rl.ipWindow = window
+	}
+}
+
+func WithIPBurst(burst int) Option {
+	return func(rl *RateLimiter) {
+		rl.ipBurst = burst
+	}
+}
+
+func WithIPMaxWait(maxWait time.Duration) Option {
+	return func(rl *RateLimiter) {
+		rl.ipMaxWait = maxWait
+	}
 }
 
-func NewRateLimiter(store Store, ipLimit int, ipBlockDuration time.Duration, tokenConfigs map[string]TokenConfig) *RateLimiter {
-	return &RateLimiter{
+func WithIPPrefix(prefix IPPrefix) Option {
+	return func(rl *RateLimiter) {
+		rl.ipPrefix = prefix
+	}
+}
+
+// WithRuleID names the rule enforced by the limiter and prefixes its keys with the ID.
+func WithRuleID(id string) Option {
+	return func(rl *RateLimiter) {
+		rl.ruleID = id
+	}
+}
+
+// WithTokenProvider looks token configs up in provider instead of tokenConfigs.
+func WithTokenProvider(provider TokenProvider) Option {
+	return func(rl *RateLimiter) {
+		rl.tokens = provider
+	}
+}
+
+// WithPlanProvider looks up the plans named by Identity.Plan in provider.
+func WithPlanProvider(provider TokenProvider) Option {
+	return func(rl *RateLimiter) {
+		rl.plans = provider
+	}
+}
+
+// WithPerTokenLimit limits every token without a config with the IP rule's settings.
+func WithPerTokenLimit() Option {
+	return func(rl *RateLimiter) {
+		rl.perToken = true
+	}
+}
+
+// WithTokenIPLimit also enforces the IP rule on tokens without a config.
+func WithTokenIPLimit() Option {
+	return func(rl *RateLimiter) {
+		rl.tokenIPLimit = true
+	}
+}
+
+// WithGlobalLimiter checks every request against global too.
+func WithGlobalLimiter(global *GlobalLimiter) Option {
+	return func(rl *RateLimiter) {
+		rl.global = global
+	}
+}
+
+func NewRateLimiter(store Store, ipLimit int, ipBlockDuration time.Duration, tokenConfigs map[string]TokenConfig, opts ...Option) *RateLimiter {
+	rl := &RateLimiter{
 		store:           store,
 		ipLimit:         ipLimit,
 		ipBlockDuration: ipBlockDuration,
-		tokenConfigs:    tokenConfigs,
+		ipAlgorithm:     FixedWindow,
+		ipWindow:        defaultWindow,
+		tokens:          StaticTokens(tokenConfigs),
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(rl)
 	}
+	return rl
 }
 
 func (rl *RateLimiter) Allow(ctx context.Context, ip string, token string) (bool, error) {
-	var key string
-	var limit int
-	var blockDuration time.Duration
+	decision, err := rl.Check(ctx, ip, token)
+	return decision.Allowed, err
+}
+
+func (rl *RateLimiter) Check(ctx context.Context, ip string, token string) (Decision, error) {
+	return rl.CheckIdentity(ctx, Identity{IP: ip, Token: token})
+}
 
-	if token != "" {
-		if config, exists := rl.tokenConfigs[token]; exists {
-			key = "token:" + token
-			limit = config.Limit
-			blockDuration = config.BlockDuration
-		} else {
+// CheckIdentity checks the request against every rule applying to id and the global limit.
+func (rl *RateLimiter) CheckIdentity(ctx context.Context, id Identity) (Decision, error) {
+	now := rl.now()
 
-			key = "ip:" + ip
-			limit = rl.ipLimit
-			blockDuration = rl.ipBlockDuration
+	rules, err := rl.resolve(ctx, id)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var global []rule
+	if rl.global != nil {
+		r, err := rl.global.rule(ctx, id)
+		if err != nil {
+			return Decision{}, err
 		}
-	} else {
+		global = append(global, r)
+	}
+
+	if len(rules)+len(global) > 1 && fixedWindows(rules) {
+		decisions, err := rl.checkFixedWindows(ctx, append(rules, global...), now)
+		if err != nil {
+			return Decision{}, err
+		}
+		if decision := combineDecisions(decisions); !decision.Allowed {
+			return decision, nil
+		}
+		return combineDecisions(decisions[:len(rules)]), nil
+	}
+
+	if len(global) > 0 {
+		decisions, err := rl.checkFixedWindows(ctx, global, now)
+		if err != nil {
+			return Decision{}, err
+		}
+		if !decisions[0].Allowed {
+			return decisions[0], nil
+		}
+	}
+
+	decisions := make([]Decision, 0, len(rules))
+	for _, r := range rules {
+		d, err := rl.check(ctx, r, now)
+		if err != nil {
+			return Decision{}, err
+		}
+		decisions = append(decisions, d)
+		if !d.Allowed {
+			break
+		}
+	}
+	return combineDecisions(decisions), nil
+}
+
+func fixedWindows(rules []rule) bool {
+	for _, r := range rules {
+		if r.algorithm != FixedWindow {
+			return false
+		}
+	}
+	return true
+}
+
+// combineDecisions returns the first rejection, or else the decision with the fewest remaining.
+func combineDecisions(decisions []Decision) Decision {
+	var decision Decision
+	var delay time.Duration
+	for i, d := range decisions {
+		if !d.Allowed {
+			return d
+		}
+
+		delay = max(delay, d.Delay)
+		if i == 0 || d.Remaining < decision.Remaining {
+			decision = d
+		}
+	}
+	decision.Delay = delay
+	return decision
+}
+
+// Refund gives back the leaky bucket slots of an allowed request that was never served.
+func (rl *RateLimiter) Refund(ctx context.Context, id Identity) error {
+	rules, err := rl.resolve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		if r.algorithm != LeakyBucket {
+			continue
+		}
+		if err := rl.refundLeakyBucket(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rl *RateLimiter) check(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	switch r.algorithm {
+	case FixedWindow:
+		if len(r.limits) > 0 {
+			return rl.checkLimits(ctx, r, now)
+		}
+		return rl.checkCounter(ctx, r, now)
+	case TokenBucket:
+		return rl.checkTokenBucket(ctx, r, now)
+	case GCRA:
+		return rl.checkGCRA(ctx, r, now)
+	case LeakyBucket:
+		return rl.checkLeakyBucket(ctx, r, now)
+	default:
+		return rl.checkCounter(ctx, r, now)
+	}
+}
+
+// checkCounter applies the counting algorithms, which block the key once the limit is exceeded.
+func (rl *RateLimiter) checkCounter(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	if atomicStore, ok := rl.store.(AtomicStore); ok && r.algorithm == FixedWindow {
+		return rl.checkAtomic(ctx, atomicStore, r, now)
+	}
+
+	decision := r.decision()
+
+	blocked, retryAfter, err := rl.blockStatus(ctx, r)
+	if err != nil {
+		return Decision{}, err
+	}
+	if blocked {
+		decision.ResetAt = now.Add(retryAfter)
+		decision.RetryAfter = retryAfter
+		return decision, nil
+	}
+
+	count, resetAt, err := rl.count(ctx, r, now)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision.ResetAt = resetAt
+
+	if count > int64(r.limit) {
+		if r.blockDuration > 0 {
+			if err := rl.store.Block(ctx, r.key, r.blockDuration); err != nil {
+				return Decision{}, err
+			}
+			decision.ResetAt = now.Add(r.blockDuration)
+		}
+		decision.RetryAfter = decision.ResetAt.Sub(now)
+		return decision, nil
+	}
+
+	decision.Allowed = true
+	decision.Remaining = r.limit - int(count)
+	return decision, nil
+}
+
+// checkAtomic applies the fixed window in a single store operation.
+func (rl *RateLimiter) checkAtomic(ctx context.Context, store AtomicStore, r rule, now time.Time) (Decision, error) {
+	result, err := store.CheckAndIncrement(ctx, r.key, int(r.window/time.Second), r.limit, r.blockDuration)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return r.checkResultDecision(result, now), nil
+}
+
+func (r rule) checkResultDecision(result CheckResult, now time.Time) Decision {
+	decision := r.decision()
+	if result.Blocked {
+		retryAfter := result.BlockTTL
+		if retryAfter == 0 {
+			retryAfter = r.blockDuration
+		}
+		decision.ResetAt = now.Add(retryAfter)
+		decision.RetryAfter = retryAfter
+		return decision
+	}
 
-		key = "ip:" + ip
-		limit = rl.ipLimit
-		blockDuration = rl.ipBlockDuration
+	decision.ResetAt = windowEnd(now, r.window)
+	if result.Count > int64(r.limit) {
+		decision.RetryAfter = decision.ResetAt.Sub(now)
+		return decision
 	}
 
-	blocked, err := rl.store.IsBlocked(ctx, key)
+	decision.Allowed = true
+	decision.Remaining = r.limit - int(result.Count)
+	return decision
+}
+
+// checkLimits applies a fixed window policy made of several limits.
+func (rl *RateLimiter) checkLimits(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	decisions, err := rl.checkFixedWindows(ctx, []rule{r}, now)
 	if err != nil {
-		return false, err
+		return Decision{}, err
+	}
+	return decisions[0], nil
+}
+
+// checkFixedWindows applies fixed window rules in a single MultiLimitStore operation.
+func (rl *RateLimiter) checkFixedWindows(ctx context.Context, rs []rule, now time.Time) ([]Decision, error) {
+	var rules []rule
+	bounds := make([]int, len(rs)+1)
+	for i, r := range rs {
+		rules = append(rules, r.limitRules()...)
+		bounds[i+1] = len(rules)
+	}
+
+	checks := make([]LimitCheck, len(rules))
+	for i, lr := range rules {
+		checks[i] = LimitCheck{
+			Key:           lr.key,
+			WindowSec:     int(lr.window / time.Second),
+			Limit:         lr.limit,
+			BlockDuration: lr.blockDuration,
+		}
+	}
+
+	var results []CheckResult
+	var err error
+	if multiStore, ok := rl.store.(MultiLimitStore); ok {
+		results, err = multiStore.CheckAndIncrementLimits(ctx, checks)
+	} else {
+		results, err = rl.checkLimitsSequentially(ctx, rules, checks)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]Decision, len(rs))
+	for i := range rs {
+		for j := bounds[i]; j < bounds[i+1]; j++ {
+			d := rules[j].checkResultDecision(results[j], now)
+			decision := &decisions[i]
+			switch {
+			case j == bounds[i]:
+				*decision = d
+			case decision.Allowed && !d.Allowed:
+				*decision = d
+			case !decision.Allowed && !d.Allowed && d.RetryAfter > decision.RetryAfter:
+				*decision = d
+			case decision.Allowed && d.Allowed && d.Remaining < decision.Remaining:
+				*decision = d
+			}
+		}
+	}
+	return decisions, nil
+}
+
+// checkLimitsSequentially is the non-atomic fallback for stores without MultiLimitStore.
+func (rl *RateLimiter) checkLimitsSequentially(ctx context.Context, rules []rule, checks []LimitCheck) ([]CheckResult, error) {
+	results := make([]CheckResult, len(checks))
+	blocked := false
+	for i, lr := range rules {
+		isBlocked, ttl, err := rl.blockStatus(ctx, lr)
+		if err != nil {
+			return nil, err
+		}
+		if isBlocked {
+			results[i] = CheckResult{Blocked: true, BlockTTL: ttl}
+			blocked = true
+		}
 	}
 	if blocked {
-		return false, nil
+		return results, nil
 	}
 
-	count, err := rl.store.Increment(ctx, key, 1)
+	for i, check := range checks {
+		count, err := rl.store.Increment(ctx, check.Key, check.WindowSec)
+		if err != nil {
+			return nil, err
+		}
+		results[i].Count = count
+		if count > int64(check.Limit) && check.BlockDuration > 0 {
+			if err := rl.store.Block(ctx, check.Key, check.BlockDuration); err != nil {
+				return nil, err
+			}
+			results[i] = CheckResult{Count: count, Blocked: true, BlockTTL: check.BlockDuration}
+		}
+	}
+	return results, nil
+}
+
+func (rl *RateLimiter) count(ctx context.Context, r rule, now time.Time) (int64, time.Time, error) {
+	switch r.algorithm {
+	case SlidingWindowLog:
+		logStore, ok := rl.store.(SlidingLogStore)
+		if !ok {
+			return 0, time.Time{}, unsupported(r.algorithm)
+		}
+		count, oldest, err := logStore.SlidingLog(ctx, r.key, r.limit, r.window)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return count, oldest.Add(r.window), nil
+	case SlidingWindowCounter:
+		counterStore, ok := rl.store.(SlidingCounterStore)
+		if !ok {
+			return 0, time.Time{}, unsupported(r.algorithm)
+		}
+		count, err := counterStore.SlidingCounter(ctx, r.key, r.limit, r.window)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return count, windowEnd(now, r.window), nil
+	default:
+		count, err := rl.store.Increment(ctx, r.key, int(r.window/time.Second))
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		return count, windowEnd(now, r.window), nil
+	}
+}
+
+func unsupported(algorithm Algorithm) error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+}
+
+// windowEnd returns the end of the epoch aligned window containing now.
+func windowEnd(now time.Time, window time.Duration) time.Time {
+	windowMs := window.Milliseconds()
+	return time.UnixMilli((now.UnixMilli()/windowMs + 1) * windowMs)
+}
+
+// fixedWindow returns the start of the epoch aligned window containing now and its TTL.
+func fixedWindow(now time.Time, windowSec int) (int64, time.Duration) {
+	window := int64(max(windowSec, 1))
+	start := now.Unix() - now.Unix()%window
+	return start, time.Duration(start+window-now.Unix()+1) * time.Second
+}
+
+func (rl *RateLimiter) blockStatus(ctx context.Context, r rule) (bool, time.Duration, error) {
+	if ttlStore, ok := rl.store.(BlockTTLStore); ok {
+		ttl, blocked, err := ttlStore.BlockTTL(ctx, r.key)
+		if err != nil {
+			return false, 0, err
+		}
+		if blocked && ttl == 0 {
+			ttl = r.blockDuration
+		}
+		return blocked, ttl, nil
+	}
+
+	blocked, err := rl.store.IsBlocked(ctx, r.key)
 	if err != nil {
-		return false, err
+		return false, 0, err
+	}
+	return blocked, r.blockDuration, nil
+}
+
+type rule struct {
+	id            string
+	key           string
+	limit         int
+	blockDuration time.Duration
+	algorithm     Algorithm
+	window        time.Duration
+	burst         int
+	cost          int
+	maxWait       time.Duration
+	limits        []Limit
+	plan          string
+}
+
+// limitRules returns a rule per limit of a multi-limit policy, starting with r itself.
+func (r rule) limitRules() []rule {
+	rules := []rule{r}
+	for _, l := range r.limits {
+		lr := r
+		lr.limit = l.Limit
+		lr.blockDuration = l.BlockDuration
+		lr.window = max(l.Window.Truncate(time.Second), time.Second)
+		lr.key = r.key + ":" + strconv.Itoa(int(lr.window/time.Second)) + "s"
+		lr.limits = nil
+		rules = append(rules, lr)
+	}
+	return rules
+}
+
+func (r rule) decision() Decision {
+	return Decision{
+		Limit:  r.limit,
+		Window: r.window,
+		Key:    r.key,
+		Rule:   r.id,
+		Plan:   r.plan,
+	}
+}
+
+// resolve returns the rules applying to id in the order they are checked.
+func (rl *RateLimiter) resolve(ctx context.Context, id Identity) ([]rule, error) {
+	ipRule := rule{
+		id:            RuleIP,
+		key:           "ip:" + rl.ipPrefix.Key(id.IP),
+		limit:         rl.ipLimit,
+		blockDuration: rl.ipBlockDuration,
+		algorithm:     rl.ipAlgorithm,
+		window:        rl.ipWindow,
+		burst:         rl.ipBurst,
+		maxWait:       rl.ipMaxWait,
 	}
 
-	if count > int64(limit) {
+	rules := []rule{ipRule}
+	if id.Token != "" {
+		config, exists, err := rl.tokenConfig(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			tokenRule := rule{
+				id:            RuleToken,
+				key:           "token:" + id.Token,
+				limit:         config.Limit,
+				blockDuration: config.BlockDuration,
+				algorithm:     config.Algorithm,
+				window:        config.Window,
+				burst:         config.Burst,
+				cost:          config.Cost,
+				maxWait:       config.MaxWait,
+				limits:        config.Limits,
+				plan:          config.Plan,
+			}
 
-		if err := rl.store.Block(ctx, key, blockDuration); err != nil {
-			return false, err
+			rules = rules[:0]
+			if config.PairLimit > 0 {
+				pairRule := tokenRule
+				pairRule.id = RulePair
+				pairRule.key = "pair:" + id.Token + ":" + ipRule.key
+				pairRule.limit = config.PairLimit
+				pairRule.burst = 0
+				pairRule.limits = nil
+				rules = append(rules, pairRule)
+			}
+			if config.CombineIP {
+				ipRule.plan = config.Plan
+				rules = append(rules, ipRule)
+			}
+			rules = append(rules, tokenRule)
 		}
-		return false, nil
 	}
 
-	return true, nil
+	for i := range rules {
+		rules[i] = rl.withDefaults(rules[i])
+	}
+	return rules, nil
+}
+
+func (rl *RateLimiter) withDefaults(r rule) rule {
+	if rl.ruleID != "" {
+		r.id = rl.ruleID
+		r.key = "rule:" + rl.ruleID + ":" + r.key
+	}
+
+	if r.algorithm == "" {
+		r.algorithm = FixedWindow
+	}
+	if r.algorithm == FixedWindow {
+		r.window = r.window.Truncate(time.Second)
+	}
+	if r.window < time.Millisecond {
+		r.window = defaultWindow
+	}
+	if r.burst <= 0 {
+		r.burst = r.limit
+	}
+	if r.cost <= 0 {
+		r.cost = 1
+	}
+	return r
+}
+
+func (rl *RateLimiter) tokenConfig(ctx context.Context, id Identity) (TokenConfig, bool, error) {
+	config, exists, err := lookupIdentity(ctx, rl.tokens, rl.plans, id)
+	if err != nil {
+		return TokenConfig{}, false, err
+	}
+	if !exists {
+		if id.Limit <= 0 && !rl.perToken {
+			return TokenConfig{}, false, nil
+		}
+		config = TokenConfig{
+			Limit:         rl.ipLimit,
+			BlockDuration: rl.ipBlockDuration,
+			Algorithm:     rl.ipAlgorithm,
+			Window:        rl.ipWindow,
+			Burst:         rl.ipBurst,
+			MaxWait:       rl.ipMaxWait,
+			CombineIP:     rl.tokenIPLimit,
+		}
+	}
+
+	if id.Limit > 0 {
+		config.Limit = id.Limit
+	}
+	return config, true, nil
 }
@@ -3,6 +3,7 @@ package limiter
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -46,13 +47,31 @@ func TestRateLimiter_Allow_BelowLimit(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !decision.Allowed {
 		t.Error("expected request to be allowed")
 	}
+	if decision.Limit != 10 {
+		t.Errorf("expected limit 10, got %d", decision.Limit)
+	}
+	if decision.Remaining != 5 {
+		t.Errorf("expected remaining 5, got %d", decision.Remaining)
+	}
+	if decision.RetryAfter != 0 {
+		t.Errorf("expected no retry-after, got %v", decision.RetryAfter)
+	}
+	if decision.Key != "ip:192.168.1.1" {
+		t.Errorf("expected key ip:192.168.1.1, got %s", decision.Key)
+	}
+	if decision.Rule != RuleIP {
+		t.Errorf("expected rule %s, got %s", RuleIP, decision.Rule)
+	}
+	if !decision.ResetAt.After(time.Now().Add(-time.Second)) {
+		t.Errorf("expected reset time in the current window, got %v", decision.ResetAt)
+	}
 }
 
 func TestRateLimiter_Allow_ExceedsLimit(t *testing.T) {
@@ -75,16 +94,22 @@ func TestRateLimiter_Allow_ExceedsLimit(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked")
 	}
 	if !blockCalled {
 		t.Error("expected Block to be called")
 	}
+	if decision.Remaining != 0 {
+		t.Errorf("expected remaining 0, got %d", decision.Remaining)
+	}
+	if decision.RetryAfter != 5*time.Minute {
+		t.Errorf("expected retry-after 5m, got %v", decision.RetryAfter)
+	}
 }
 
 func TestRateLimiter_Allow_AlreadyBlocked(t *testing.T) {
@@ -101,11 +126,11 @@ func TestRateLimiter_Allow_AlreadyBlocked(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked")
 	}
 	if incrementCalled {
@@ -134,16 +159,25 @@ func TestRateLimiter_Allow_TokenConfigTakesPrecedence(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, tokenConfigs)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "abc123")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "abc123")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !decision.Allowed {
 		t.Error("expected request to be allowed with token config")
 	}
 	if capturedKey != "token:abc123" {
 		t.Errorf("expected key token:abc123, got %s", capturedKey)
 	}
+	if decision.Rule != RuleToken {
+		t.Errorf("expected rule %s, got %s", RuleToken, decision.Rule)
+	}
+	if decision.Limit != 100 {
+		t.Errorf("expected limit 100, got %d", decision.Limit)
+	}
+	if decision.Remaining != 50 {
+		t.Errorf("expected remaining 50, got %d", decision.Remaining)
+	}
 }
 
 func TestRateLimiter_Allow_UnconfiguredTokenUsesIPLimit(t *testing.T) {
@@ -167,11 +201,11 @@ func TestRateLimiter_Allow_UnconfiguredTokenUsesIPLimit(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, tokenConfigs)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "unknown_token")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "unknown_token")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !decision.Allowed {
 		t.Error("expected request to be allowed")
 	}
 	if capturedKey != "ip:192.168.1.1" {
@@ -189,11 +223,11 @@ func TestRateLimiter_Allow_StoreError(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != testErr {
 		t.Errorf("expected error %v, got %v", testErr, err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked on error")
 	}
 }
@@ -210,13 +244,16 @@ func TestRateLimiter_Allow_ExactLimit(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !decision.Allowed {
 		t.Error("expected request to be allowed at exact limit")
 	}
+	if decision.Remaining != 0 {
+		t.Errorf("expected remaining 0, got %d", decision.Remaining)
+	}
 }
 
 func TestRateLimiter_Allow_IncrementError(t *testing.T) {
@@ -232,11 +269,11 @@ func TestRateLimiter_Allow_IncrementError(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != testErr {
 		t.Errorf("expected error %v, got %v", testErr, err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked on increment error")
 	}
 }
@@ -257,11 +294,11 @@ func TestRateLimiter_Allow_BlockError(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != testErr {
 		t.Errorf("expected error %v, got %v", testErr, err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked on block error")
 	}
 }
@@ -314,11 +351,11 @@ func TestRateLimiter_Allow_MultipleTokens(t *testing.T) {
 
 			rl := NewRateLimiter(store, 10, 5*time.Minute, tokenConfigs)
 
-			allowed, err := rl.Allow(context.Background(), "192.168.1.1", tt.token)
+			decision, err := rl.Check(context.Background(), "192.168.1.1", tt.token)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			if !allowed {
+			if !decision.Allowed {
 				t.Error("expected request to be allowed")
 			}
 			if capturedKey != tt.expectedKey {
@@ -342,11 +379,11 @@ func TestRateLimiter_Allow_EmptyToken(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !decision.Allowed {
 		t.Error("expected request to be allowed")
 	}
 	if capturedKey != "ip:192.168.1.1" {
@@ -370,11 +407,11 @@ func TestRateLimiter_Allow_DifferentIPs(t *testing.T) {
 
 	ips := []string{"192.168.1.1", "192.168.1.2", "10.0.0.1"}
 	for _, ip := range ips {
-		allowed, err := rl.Allow(context.Background(), ip, "")
+		decision, err := rl.Check(context.Background(), ip, "")
 		if err != nil {
 			t.Fatalf("unexpected error for IP %s: %v", ip, err)
 		}
-		if !allowed {
+		if !decision.Allowed {
 			t.Errorf("expected request to be allowed for IP %s", ip)
 		}
 	}
@@ -396,11 +433,11 @@ func TestRateLimiter_Allow_ContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	allowed, err := rl.Allow(ctx, "192.168.1.1", "")
+	decision, err := rl.Check(ctx, "192.168.1.1", "")
 	if err != context.Canceled {
 		t.Errorf("expected context.Canceled error, got %v", err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked on context error")
 	}
 }
@@ -429,14 +466,585 @@ func TestRateLimiter_Allow_TokenBlockDuration(t *testing.T) {
 
 	rl := NewRateLimiter(store, 10, 5*time.Minute, tokenConfigs)
 
-	allowed, err := rl.Allow(context.Background(), "192.168.1.1", "special-token")
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "special-token")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if allowed {
+	if decision.Allowed {
 		t.Error("expected request to be blocked")
 	}
 	if capturedDuration != 15*time.Minute {
 		t.Errorf("expected block duration 15m, got %v", capturedDuration)
 	}
 }
+
+type ttlStore struct {
+	mockStore
+	ttl     time.Duration
+	blocked bool
+}
+
+func (s *ttlStore) BlockTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	return s.ttl, s.blocked, nil
+}
+
+func TestRateLimiter_Check_BlockedRetryAfterUsesTTL(t *testing.T) {
+	store := &ttlStore{
+		mockStore: mockStore{
+			isBlockedFunc: func(ctx context.Context, key string) (bool, error) {
+				t.Error("expected IsBlocked not to be called when BlockTTL is supported")
+				return true, nil
+			},
+		},
+		ttl:     42 * time.Second,
+		blocked: true,
+	}
+
+	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected request to be blocked")
+	}
+	if decision.RetryAfter != 42*time.Second {
+		t.Errorf("expected retry-after 42s, got %v", decision.RetryAfter)
+	}
+}
+
+func TestRateLimiter_Check_NotBlockedWithTTLStore(t *testing.T) {
+	store := &ttlStore{
+		mockStore: mockStore{
+			incrementFunc: func(ctx context.Context, key string, windowSec int) (int64, error) {
+				return 1, nil
+			},
+		},
+	}
+
+	rl := NewRateLimiter(store, 10, 5*time.Minute, nil)
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected request to be allowed")
+	}
+	if decision.Remaining != 9 {
+		t.Errorf("expected remaining 9, got %d", decision.Remaining)
+	}
+}
+
+func TestRateLimiter_Check_UnsupportedAlgorithm(t *testing.T) {
+	store := &mockStore{}
+
+	rl := NewRateLimiter(store, 10, 5*time.Minute, nil, WithIPAlgorithm(SlidingWindowLog))
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected request to be blocked on error")
+	}
+}
+
+func TestRateLimiter_CheckIdentity_PlanAndLimit(t *testing.T) {
+	store := &mockStore{
+		incrementFunc: func(ctx context.Context, key string, windowSec int) (int64, error) {
+			return 1, nil
+		},
+	}
+	tokenConfigs := map[string]TokenConfig{
+		"pro": {Limit: 100, BlockDuration: time.Minute},
+	}
+	rl := NewRateLimiter(store, 10, 5*time.Minute, tokenConfigs, WithPerTokenLimit())
+
+	tests := []struct {
+		name      string
+		id        Identity
+		wantRule  string
+		wantKey   string
+		wantLimit int
+	}{
+		{"unknown claim gets its own ip-like rule", Identity{IP: "192.168.1.1", Token: "jwt:user-1"}, RuleToken, "token:jwt:user-1", 10},
+		{"plan selects token config", Identity{IP: "192.168.1.1", Token: "jwt:user-1", Plan: "pro"}, RuleToken, "token:jwt:user-1", 100},
+		{"unknown plan gets its own ip-like rule", Identity{IP: "192.168.1.1", Token: "jwt:user-1", Plan: "gold"}, RuleToken, "token:jwt:user-1", 10},
+		{"limit overrides plan", Identity{IP: "192.168.1.1", Token: "jwt:user-1", Plan: "pro", Limit: 500}, RuleToken, "token:jwt:user-1", 500},
+		{"limit without config", Identity{IP: "192.168.1.1", Token: "jwt:user-2", Limit: 50}, RuleToken, "token:jwt:user-2", 50},
+		{"ip without token", Identity{IP: "192.168.1.1"}, RuleIP, "ip:192.168.1.1", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := rl.CheckIdentity(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Rule != tt.wantRule || decision.Key != tt.wantKey || decision.Limit != tt.wantLimit {
+				t.Errorf("expected rule %s key %s limit %d, got rule %s key %s limit %d",
+					tt.wantRule, tt.wantKey, tt.wantLimit, decision.Rule, decision.Key, decision.Limit)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_CheckIdentity_Plans(t *testing.T) {
+	store, _ := newTestMemoryStore(t, 0)
+	plans := StaticTokens{
+		"free": {Limit: 1, BlockDuration: time.Minute, CombineIP: true},
+		"pro":  {Limit: 100, BlockDuration: time.Minute},
+	}
+	tokens := StaticTokens{
+		"abc123": {Plan: "pro"},
+		"xyz789": {Plan: "pro", Limit: 200},
+		"free":   {Limit: 3},
+	}
+	rl := NewRateLimiter(store, 2, time.Minute, nil,
+		WithTokenProvider(ResolvePlans(tokens, plans)),
+		WithPlanProvider(plans),
+	)
+
+	tests := []struct {
+		name      string
+		id        Identity
+		wantPlan  string
+		wantLimit int
+	}{
+		{"token plan", Identity{IP: "192.168.1.1", Token: "abc123"}, "pro", 100},
+		{"token override", Identity{IP: "192.168.1.1", Token: "xyz789"}, "pro", 200},
+		{"identity plan", Identity{IP: "192.168.1.2", Token: "user-1", Plan: "free"}, "free", 1},
+		{"token without plan", Identity{IP: "192.168.1.3", Token: "free"}, "", 3},
+		{"ip", Identity{IP: "192.168.1.4"}, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := rl.CheckIdentity(context.Background(), tt.id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Plan != tt.wantPlan || decision.Limit != tt.wantLimit {
+				t.Errorf("expected plan %q limit %d, got plan %q limit %d", tt.wantPlan, tt.wantLimit, decision.Plan, decision.Limit)
+			}
+		})
+	}
+
+	// The free plan combines the IP limit of 2, which rejects the third
+	// request; the decision still names the plan.
+	id := Identity{IP: "192.168.1.5", Token: "user-2", Plan: "free"}
+	for i := 0; i < 2; i++ {
+		if _, err := rl.CheckIdentity(context.Background(), Identity{IP: id.IP}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	decision, err := rl.CheckIdentity(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed || decision.Rule != RuleIP || decision.Plan != "free" {
+		t.Errorf("expected the IP rule to reject the request of plan free, got %+v", decision)
+	}
+
+	plans["pro"] = TokenConfig{Limit: 500, BlockDuration: time.Minute}
+	decision, err = rl.CheckIdentity(context.Background(), Identity{IP: "192.168.1.1", Token: "abc123"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Limit != 500 {
+		t.Errorf("expected a changed plan to apply to its tokens, got limit %d", decision.Limit)
+	}
+}
+
+func TestRateLimiter_RuleIDPrefixesKeys(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	login := NewRateLimiter(store, 1, 0, nil, WithRuleID("login"))
+	search := NewRateLimiter(store, 1, 0, nil, WithRuleID("search"))
+	login.now, search.now = clock.Now, clock.Now
+	ctx := context.Background()
+
+	decision, err := login.Check(ctx, "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Rule != "login" || decision.Key != "rule:login:ip:192.168.1.1" {
+		t.Errorf("expected rule login with prefixed key, got rule %s key %s", decision.Rule, decision.Key)
+	}
+
+	decision, err = search.Check(ctx, "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected rules not to share counters")
+	}
+}
+
+func TestRateLimiter_PerTokenLimit(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 1, 0, nil, WithPerTokenLimit())
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	for _, token := range []string{"session-a", "session-b"} {
+		decision, err := rl.Check(ctx, "192.168.1.1", token)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed || decision.Key != "token:"+token || decision.Limit != 1 {
+			t.Errorf("expected %s to get its own limit of 1, got %+v", token, decision)
+		}
+	}
+
+	decision, err := rl.Check(ctx, "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed || decision.Rule != RuleIP {
+		t.Errorf("expected requests without a token to be limited by ip, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_TokenIPLimit(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 2, 0, nil, WithPerTokenLimit(), WithTokenIPLimit())
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		decision, err := rl.Check(ctx, "192.168.1.1", fmt.Sprintf("session-%d", i))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		} else if decision.Rule != RuleIP {
+			t.Errorf("expected the ip rule to reject, got %+v", decision)
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected rotating tokens to stay within the ip limit of 2, got %d allowed", allowed)
+	}
+
+	decision, err := rl.Check(ctx, "192.168.1.2", "session-0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed || decision.Key != "token:session-0" {
+		t.Errorf("expected session-0 to keep its own limit, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_FixedWindowLength(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 3, 0, nil, WithIPWindow(time.Minute))
+	rl.now = clock.Now
+	ctx := context.Background()
+	clock.Advance(20 * time.Second)
+
+	if got := sendBurst(t, rl, 5); got != 3 {
+		t.Fatalf("expected 3 requests allowed per minute, got %d", got)
+	}
+
+	decision, err := rl.Check(ctx, "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Window != time.Minute || decision.RetryAfter != 40*time.Second {
+		t.Errorf("expected 1m window with retry-after 40s, got window %v retry-after %v", decision.Window, decision.RetryAfter)
+	}
+
+	clock.Advance(40 * time.Second)
+	if got := sendBurst(t, rl, 1); got != 1 {
+		t.Error("expected request to be allowed in the next window")
+	}
+}
+
+func multiLimitConfigs() map[string]TokenConfig {
+	return map[string]TokenConfig{
+		"abc": {
+			Limit:  2,
+			Window: time.Second,
+			Limits: []Limit{{Limit: 5, Window: time.Minute, BlockDuration: 10 * time.Minute}},
+		},
+	}
+}
+
+func TestRateLimiter_MultipleLimits(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 10, 0, multiLimitConfigs())
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	check := func() Decision {
+		t.Helper()
+		decision, err := rl.Check(ctx, "192.168.1.1", "abc")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return decision
+	}
+
+	check()
+	if decision := check(); !decision.Allowed || decision.Remaining != 0 || decision.Window != time.Second {
+		t.Errorf("expected the per-second limit to be the closest, got %+v", decision)
+	}
+	if decision := check(); decision.Allowed || decision.Window != time.Second || decision.RetryAfter != time.Second {
+		t.Errorf("expected the per-second limit to trip, got %+v", decision)
+	}
+
+	// The rejected request was not counted against the per-minute limit.
+	clock.Advance(time.Second)
+	check()
+	if decision := check(); !decision.Allowed || decision.Remaining != 0 || decision.Window != time.Second {
+		t.Errorf("expected 4 of 5 requests of the minute to be used, got %+v", decision)
+	}
+
+	clock.Advance(time.Second)
+	if decision := check(); !decision.Allowed || decision.Remaining != 0 || decision.Window != time.Minute {
+		t.Errorf("expected the per-minute limit to be exhausted, got %+v", decision)
+	}
+
+	decision := check()
+	if decision.Allowed || decision.Limit != 5 || decision.Window != time.Minute || decision.Key != "token:abc:60s" {
+		t.Errorf("expected the per-minute limit to trip, got %+v", decision)
+	}
+	if decision.RetryAfter != 10*time.Minute {
+		t.Errorf("expected the per-minute limit to block for 10m, got %v", decision.RetryAfter)
+	}
+
+	clock.Advance(time.Minute)
+	if decision := check(); decision.Allowed || decision.Window != time.Minute {
+		t.Errorf("expected the key to stay blocked by the per-minute limit, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_MultipleLimitsWithoutMultiLimitStore(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(struct{ Store }{store}, 10, 0, multiLimitConfigs())
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	// Without an atomic store rejected requests count against every limit:
+	// the third request of each second is rejected by the per-second limit
+	// and the sixth of the minute trips the per-minute limit.
+	var decision Decision
+	for i := 0; i < 3; i++ {
+		var err error
+		decision, err = rl.Check(ctx, "192.168.1.1", "abc")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i < 2 && !decision.Allowed {
+			t.Errorf("request %d: expected request to be allowed", i+1)
+		}
+	}
+	if decision.Allowed || decision.Window != time.Second {
+		t.Errorf("expected the per-second limit to trip, got %+v", decision)
+	}
+
+	clock.Advance(time.Second)
+	for i := 0; i < 3; i++ {
+		var err error
+		decision, err = rl.Check(ctx, "192.168.1.1", "abc")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if decision.Allowed || decision.Window != time.Minute || decision.RetryAfter != 10*time.Minute {
+		t.Errorf("expected the per-minute limit to block the key, got %+v", decision)
+	}
+}
+
+func TestRateLimiter_CombinedLimits(t *testing.T) {
+	type request struct{ ip, token string }
+
+	tests := []struct {
+		name     string
+		ipLimit  int
+		config   TokenConfig
+		requests []request
+		// wantRule is the rule rejecting the last request; every request
+		// before it is allowed. Empty means every request is allowed.
+		wantRule string
+	}{
+		{
+			name:     "token replaces ip by default",
+			ipLimit:  1,
+			config:   TokenConfig{Limit: 3},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RuleToken,
+		},
+		{
+			name:     "ip trips first",
+			ipLimit:  2,
+			config:   TokenConfig{Limit: 5, CombineIP: true},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RuleIP,
+		},
+		{
+			name:     "ip trips first when rotating tokens",
+			ipLimit:  2,
+			config:   TokenConfig{Limit: 5, CombineIP: true},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t2"}, {"10.0.0.1", "t3"}},
+			wantRule: RuleIP,
+		},
+		{
+			name:     "token trips first",
+			ipLimit:  5,
+			config:   TokenConfig{Limit: 2, CombineIP: true},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.2", "t1"}, {"10.0.0.3", "t1"}},
+			wantRule: RuleToken,
+		},
+		{
+			name:     "ip and token trip together",
+			ipLimit:  2,
+			config:   TokenConfig{Limit: 2, CombineIP: true},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RuleIP,
+		},
+		{
+			name:     "pair trips first",
+			ipLimit:  5,
+			config:   TokenConfig{Limit: 5, CombineIP: true, PairLimit: 1},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.2", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RulePair,
+		},
+		{
+			name:     "ip trips before pair",
+			ipLimit:  2,
+			config:   TokenConfig{Limit: 5, CombineIP: true, PairLimit: 3},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t2"}, {"10.0.0.1", "t1"}},
+			wantRule: RuleIP,
+		},
+		{
+			name:     "token trips before pair and ip",
+			ipLimit:  5,
+			config:   TokenConfig{Limit: 3, CombineIP: true, PairLimit: 2},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.2", "t1"}, {"10.0.0.3", "t1"}, {"10.0.0.4", "t1"}},
+			wantRule: RuleToken,
+		},
+		{
+			name:     "pair, ip and token trip together",
+			ipLimit:  1,
+			config:   TokenConfig{Limit: 1, CombineIP: true, PairLimit: 1},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RulePair,
+		},
+		{
+			name:     "pair without ip",
+			ipLimit:  1,
+			config:   TokenConfig{Limit: 5, PairLimit: 1},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.2", "t1"}, {"10.0.0.3", "t1"}},
+		},
+		{
+			name:     "pair trips without ip",
+			ipLimit:  1,
+			config:   TokenConfig{Limit: 5, PairLimit: 1},
+			requests: []request{{"10.0.0.1", "t1"}, {"10.0.0.1", "t1"}},
+			wantRule: RulePair,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, clock := newTestMemoryStore(t, 0)
+			configs := map[string]TokenConfig{"t1": tt.config, "t2": tt.config, "t3": tt.config}
+			rl := NewRateLimiter(store, tt.ipLimit, 0, configs)
+			rl.now = clock.Now
+
+			for i, req := range tt.requests {
+				decision, err := rl.Check(context.Background(), req.ip, req.token)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				last := i == len(tt.requests)-1
+				if !last || tt.wantRule == "" {
+					if !decision.Allowed {
+						t.Fatalf("request %d: expected request to be allowed, rejected by %s", i+1, decision.Rule)
+					}
+					continue
+				}
+				if decision.Allowed || decision.Rule != tt.wantRule {
+					t.Errorf("request %d: expected rejection by %s, got %+v", i+1, tt.wantRule, decision)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_CombinedLimitsCountOnlyAllowedRequests(t *testing.T) {
+	redisStore, redisClock := newTestRedisStore(t)
+	memoryStore, memoryClock := newTestMemoryStore(t, 0)
+	stores := map[string]struct {
+		store Store
+		clock *testClock
+	}{
+		"redis":  {redisStore, redisClock},
+		"memory": {memoryStore, memoryClock},
+	}
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			configs := map[string]TokenConfig{
+				"t1": {Limit: 1, CombineIP: true, PairLimit: 5},
+				"t2": {Limit: 5, CombineIP: true},
+			}
+			rl := NewRateLimiter(s.store, 3, 0, configs)
+			rl.now = s.clock.Now
+
+			// t1 spends its single request; the rejected ones must not
+			// count against the pair and IP limits it shares.
+			for i := 0; i < 4; i++ {
+				decision, err := rl.Check(context.Background(), "10.0.0.1", "t1")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if allowed := i == 0; decision.Allowed != allowed {
+					t.Fatalf("request %d: expected allowed %v, got %+v", i+1, allowed, decision)
+				}
+				if i > 0 && decision.Rule != RuleToken {
+					t.Fatalf("request %d: expected rejection by the token limit, got %s", i+1, decision.Rule)
+				}
+			}
+
+			for i := 0; i < 2; i++ {
+				decision, err := rl.Check(context.Background(), "10.0.0.1", "t2")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !decision.Allowed {
+					t.Fatalf("t2 request %d: expected request to be allowed, rejected by %s", i+1, decision.Rule)
+				}
+				if want := 1 - i; decision.Rule != RuleIP || decision.Remaining != want {
+					t.Errorf("t2 request %d: expected the ip limit with %d remaining, got %+v", i+1, want, decision)
+				}
+			}
+
+			decision, err := rl.Check(context.Background(), "10.0.0.1", "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed || decision.Rule != RuleIP {
+				t.Errorf("expected the ip limit to be spent by the allowed requests only, got %+v", decision)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_CombinedLimitsReportClosestLimit(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	rl := NewRateLimiter(store, 2, 0, map[string]TokenConfig{"t1": {Limit: 10, CombineIP: true}})
+	rl.now = clock.Now
+
+	decision, err := rl.Check(context.Background(), "10.0.0.1", "t1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed || decision.Rule != RuleIP || decision.Remaining != 1 {
+		t.Errorf("expected the ip limit with 1 remaining, got %+v", decision)
+	}
+}
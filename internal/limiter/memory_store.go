@@ -0,0 +1,540 @@
+package limiter
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	memoryShardCount       = 32
+	memoryEvictionSamples  = 5
+	defaultJanitorInterval = time.Minute
+)
+
+// MemoryStore is an in-process Store for single-instance deployments and tests.
+type MemoryStore struct {
+	shards []*memoryShard
+	now    func() time.Time
+	done   chan struct{}
+	once   sync.Once
+}
+
+type memoryShard struct {
+	mu         sync.Mutex
+	entries    map[string]*memoryEntry
+	maxEntries int
+}
+
+type memoryEntry struct {
+	expiresAt time.Time
+	count     int64
+	log       []time.Time
+	tokens    float64
+	updatedAt time.Time
+	tat       time.Time
+	leases    map[string]time.Time
+}
+
+func NewMemoryStore(maxEntries int, janitorInterval time.Duration) *MemoryStore {
+	if janitorInterval <= 0 {
+		janitorInterval = defaultJanitorInterval
+	}
+
+	perShard := 0
+	if maxEntries > 0 {
+		perShard = max(1, maxEntries/memoryShardCount)
+	}
+
+	m := &MemoryStore{
+		shards: make([]*memoryShard, memoryShardCount),
+		now:    time.Now,
+		done:   make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{
+			entries:    make(map[string]*memoryEntry),
+			maxEntries: perShard,
+		}
+	}
+
+	go m.janitor(janitorInterval)
+	return m
+}
+
+// SetClock makes the store read the current time from now, e.g. a test clock.
+func (m *MemoryStore) SetClock(now func() time.Time) {
+	m.now = now
+}
+
+// Close stops the background janitor.
+func (m *MemoryStore) Close() {
+	m.once.Do(func() { close(m.done) })
+}
+
+func (m *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.sweep()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *MemoryStore) sweep() {
+	now := m.now()
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for k, e := range shard.entries {
+			if e.expired(now) {
+				delete(shard.entries, k)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// shard returns the shard holding every entry of the given rate limit key.
+func (m *MemoryStore) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%memoryShardCount]
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func (e *memoryEntry) extend(expiresAt time.Time) {
+	if expiresAt.After(e.expiresAt) {
+		e.expiresAt = expiresAt
+	}
+}
+
+func (s *memoryShard) get(name string, now time.Time) *memoryEntry {
+	e, ok := s.entries[name]
+	if !ok {
+		return nil
+	}
+	if e.expired(now) {
+		delete(s.entries, name)
+		return nil
+	}
+	return e
+}
+
+func (s *memoryShard) getOrCreate(name string, now time.Time) *memoryEntry {
+	if e := s.get(name, now); e != nil {
+		return e
+	}
+
+	if s.maxEntries > 0 && len(s.entries) >= s.maxEntries {
+		s.evict(now)
+	}
+
+	e := &memoryEntry{}
+	s.entries[name] = e
+	return e
+}
+
+// evict removes an expired entry or the sampled counter closest to expiry, never a block.
+func (s *memoryShard) evict(now time.Time) {
+	var victim string
+	var victimExpiry time.Time
+	sampled := 0
+
+	for k, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, k)
+			return
+		}
+		if strings.HasPrefix(k, "blocked:") {
+			continue
+		}
+
+		expiry := e.expiresAt
+		if expiry.IsZero() {
+			expiry = time.Unix(math.MaxInt32, 0)
+		}
+		if victim == "" || expiry.Before(victimExpiry) {
+			victim, victimExpiry = k, expiry
+		}
+
+		sampled++
+		if sampled == memoryEvictionSamples {
+			break
+		}
+	}
+
+	if victim != "" {
+		delete(s.entries, victim)
+	}
+}
+
+func (m *MemoryStore) Increment(ctx context.Context, key string, windowSec int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return shard.increment(key, windowSec, now), nil
+}
+
+func (s *memoryShard) increment(key string, windowSec int, now time.Time) int64 {
+	start, ttl := fixedWindow(now, windowSec)
+	e := s.getOrCreate("window:"+key+":"+strconv.FormatInt(start, 10), now)
+	e.count++
+	e.expiresAt = now.Add(ttl)
+	return e.count
+}
+
+func (m *MemoryStore) IsBlocked(ctx context.Context, key string) (bool, error) {
+	_, blocked, err := m.BlockTTL(ctx, key)
+	return blocked, err
+}
+
+func (m *MemoryStore) Block(ctx context.Context, key string, duration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.block(key, duration, now)
+	return nil
+}
+
+func (s *memoryShard) block(key string, duration time.Duration, now time.Time) {
+	e := s.getOrCreate("blocked:"+key, now)
+	e.expiresAt = time.Time{}
+	if duration > 0 {
+		e.expiresAt = now.Add(duration)
+	}
+}
+
+func (m *MemoryStore) BlockTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	ttl, blocked := shard.blockTTL(key, now)
+	return ttl, blocked, nil
+}
+
+func (s *memoryShard) blockTTL(key string, now time.Time) (time.Duration, bool) {
+	e := s.get("blocked:"+key, now)
+	if e == nil {
+		return 0, false
+	}
+	if e.expiresAt.IsZero() {
+		return 0, true
+	}
+	return e.expiresAt.Sub(now), true
+}
+
+func (m *MemoryStore) CheckAndIncrement(ctx context.Context, key string, windowSec int, limit int, blockDuration time.Duration) (CheckResult, error) {
+	if err := ctx.Err(); err != nil {
+		return CheckResult{}, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if ttl, blocked := shard.blockTTL(key, now); blocked {
+		return CheckResult{Blocked: true, BlockTTL: ttl}, nil
+	}
+
+	count := shard.increment(key, windowSec, now)
+	if count > int64(limit) && blockDuration > 0 {
+		shard.block(key, blockDuration, now)
+		return CheckResult{Count: count, Blocked: true, BlockTTL: blockDuration}, nil
+	}
+
+	return CheckResult{Count: count}, nil
+}
+
+func (m *MemoryStore) CheckAndIncrementLimits(ctx context.Context, checks []LimitCheck) ([]CheckResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	shards := make([]*memoryShard, len(checks))
+	for i, check := range checks {
+		shards[i] = m.shard(check.Key)
+	}
+	unlock := m.lockShards(shards)
+	defer unlock()
+
+	results := make([]CheckResult, len(checks))
+	blocked := false
+	for i, check := range checks {
+		if ttl, isBlocked := shards[i].blockTTL(check.Key, now); isBlocked {
+			results[i] = CheckResult{Blocked: true, BlockTTL: ttl}
+			blocked = true
+		}
+	}
+	if blocked {
+		return results, nil
+	}
+
+	exceeded := false
+	for i, check := range checks {
+		start, _ := fixedWindow(now, check.WindowSec)
+		results[i].Count = 1
+		if e := shards[i].get("window:"+check.Key+":"+strconv.FormatInt(start, 10), now); e != nil {
+			results[i].Count += e.count
+		}
+		if results[i].Count > int64(check.Limit) {
+			exceeded = true
+		}
+	}
+
+	for i, check := range checks {
+		if !exceeded {
+			shards[i].increment(check.Key, check.WindowSec, now)
+		} else if results[i].Count > int64(check.Limit) && check.BlockDuration > 0 {
+			shards[i].block(check.Key, check.BlockDuration, now)
+			results[i].Blocked, results[i].BlockTTL = true, check.BlockDuration
+		}
+	}
+	return results, nil
+}
+
+// lockShards locks each distinct shard in a fixed order and returns a function unlocking them.
+func (m *MemoryStore) lockShards(shards []*memoryShard) func() {
+	var locked []*memoryShard
+	for _, shard := range m.shards {
+		if slices.Contains(shards, shard) {
+			shard.mu.Lock()
+			locked = append(locked, shard)
+		}
+	}
+	return func() {
+		for _, shard := range locked {
+			shard.mu.Unlock()
+		}
+	}
+}
+
+func (m *MemoryStore) SlidingLog(ctx context.Context, key string, limit int, window time.Duration) (int64, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var log []time.Time
+	if e := shard.get("log:"+key, now); e != nil {
+		cutoff := now.Add(-window)
+		trimmed := 0
+		for trimmed < len(e.log) && !e.log[trimmed].After(cutoff) {
+			trimmed++
+		}
+		e.log = e.log[trimmed:]
+		log = e.log
+	}
+
+	count := int64(len(log))
+	if count < int64(limit) {
+		e := shard.getOrCreate("log:"+key, now)
+		e.log = append(e.log, now)
+		e.expiresAt = now.Add(window)
+		log = e.log
+	}
+
+	oldest := now
+	if len(log) > 0 {
+		oldest = log[0]
+	}
+	return count + 1, oldest, nil
+}
+
+func (m *MemoryStore) SlidingCounter(ctx context.Context, key string, limit int, window time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := m.now()
+	nowMs := now.UnixMilli()
+	windowMs := window.Milliseconds()
+	index := nowMs / windowMs
+	remaining := windowMs - nowMs%windowMs
+
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var previous int64
+	if e := shard.get("swc:"+key+":"+strconv.FormatInt(index-1, 10), now); e != nil {
+		previous = e.count
+	}
+
+	currentName := "swc:" + key + ":" + strconv.FormatInt(index, 10)
+	var current int64
+	if e := shard.get(currentName, now); e != nil {
+		current = e.count
+	}
+
+	estimate := previous*remaining/windowMs + current
+	if estimate < int64(limit) {
+		e := shard.getOrCreate(currentName, now)
+		e.count++
+		e.expiresAt = now.Add(2 * window)
+	}
+
+	return estimate + 1, nil
+}
+
+func (m *MemoryStore) TakeTokens(ctx context.Context, key string, capacity int, interval time.Duration, cost int) (bool, float64, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.get("bucket:"+key, now)
+	if e == nil {
+		e = shard.getOrCreate("bucket:"+key, now)
+		e.tokens = float64(capacity)
+		e.updatedAt = now
+	}
+
+	if now.After(e.updatedAt) {
+		e.tokens = math.Min(float64(capacity), e.tokens+float64(now.Sub(e.updatedAt))/float64(interval))
+		e.updatedAt = now
+	}
+
+	allowed := false
+	if e.tokens >= float64(cost) {
+		e.tokens -= float64(cost)
+		allowed = true
+	}
+	e.expiresAt = now.Add(time.Duration((float64(capacity)-e.tokens)*float64(interval)) + time.Second)
+
+	return allowed, e.tokens, nil
+}
+
+func (m *MemoryStore) GCRA(ctx context.Context, key string, interval, tolerance time.Duration, cost int) (bool, time.Duration, time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, 0, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	tat := now
+	if e := shard.get("gcra:"+key, now); e != nil && e.tat.After(now) {
+		tat = e.tat
+	}
+
+	newTat := tat.Add(interval * time.Duration(cost))
+	allowAt := newTat.Add(-tolerance)
+	if now.Before(allowAt) {
+		return false, allowAt.Sub(now), tat.Sub(now), nil
+	}
+
+	e := shard.getOrCreate("gcra:"+key, now)
+	e.tat = newTat
+	e.expiresAt = newTat
+	return true, 0, newTat.Sub(now), nil
+}
+
+func (m *MemoryStore) Acquire(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (bool, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.getOrCreate("inflight:"+key, now)
+	if e.leases == nil {
+		e.leases = make(map[string]time.Time)
+	}
+	for id, expiresAt := range e.leases {
+		if !now.Before(expiresAt) {
+			delete(e.leases, id)
+		}
+	}
+
+	inFlight := int64(len(e.leases))
+	if inFlight >= int64(limit) {
+		return false, inFlight, nil
+	}
+
+	e.leases[leaseID] = now.Add(ttl)
+	e.extend(now.Add(ttl))
+	return true, inFlight + 1, nil
+}
+
+func (m *MemoryStore) Extend(ctx context.Context, key string, leaseID string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.get("inflight:"+key, now)
+	if e == nil {
+		return nil
+	}
+	if _, ok := e.leases[leaseID]; ok {
+		e.leases[leaseID] = now.Add(ttl)
+		e.extend(now.Add(ttl))
+	}
+	return nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key string, leaseID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := m.now()
+	shard := m.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if e := shard.get("inflight:"+key, now); e != nil {
+		delete(e.leases, leaseID)
+	}
+	return nil
+}
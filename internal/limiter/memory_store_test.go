@@ -0,0 +1,165 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMemoryStore(t *testing.T, maxEntries int) (*MemoryStore, *testClock) {
+	clock := &testClock{now: testEpoch}
+	store := NewMemoryStore(maxEntries, time.Hour)
+	store.now = clock.Now
+	t.Cleanup(store.Close)
+	return store, clock
+}
+
+func entryCount(m *MemoryStore) int {
+	total := 0
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		total += len(shard.entries)
+		shard.mu.Unlock()
+	}
+	return total
+}
+
+func TestMemoryStore_SweepRemovesExpiredEntries(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if _, err := store.Increment(ctx, fmt.Sprintf("ip:10.0.0.%d", i), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := store.Block(ctx, "ip:10.0.0.1", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	store.sweep()
+
+	if got := entryCount(store); got != 1 {
+		t.Errorf("expected only the block entry to survive the sweep, got %d entries", got)
+	}
+}
+
+func TestMemoryStore_EvictsWhenFull(t *testing.T) {
+	store, _ := newTestMemoryStore(t, memoryShardCount)
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := store.Increment(ctx, fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := entryCount(store); got > memoryShardCount {
+		t.Errorf("expected at most %d entries, got %d", memoryShardCount, got)
+	}
+}
+
+func TestMemoryStore_EvictionKeepsBlocks(t *testing.T) {
+	store, _ := newTestMemoryStore(t, memoryShardCount)
+	ctx := context.Background()
+
+	if err := store.Block(ctx, "ip:192.168.1.1", time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if _, err := store.Increment(ctx, fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := entryCount(store); got > memoryShardCount+1 {
+		t.Errorf("expected at most %d entries, got %d", memoryShardCount+1, got)
+	}
+	blocked, err := store.IsBlocked(ctx, "ip:192.168.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !blocked {
+		t.Error("expected the block to survive eviction")
+	}
+}
+
+func TestMemoryStore_IncrementWindowExpiry(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := store.Increment(ctx, "ip:10.0.0.1", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != want {
+			t.Errorf("expected count %d, got %d", want, count)
+		}
+	}
+
+	clock.Advance(time.Second)
+	if count, err := store.Increment(ctx, "ip:10.0.0.1", 1); err != nil || count != 1 {
+		t.Errorf("expected count to restart in the next window, got %d (%v)", count, err)
+	}
+}
+
+func TestMemoryStore_BlockTTL(t *testing.T) {
+	store, clock := newTestMemoryStore(t, 0)
+	ctx := context.Background()
+
+	if err := store.Block(ctx, "ip:10.0.0.1", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock.Advance(20 * time.Second)
+	ttl, blocked, err := store.BlockTTL(ctx, "ip:10.0.0.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !blocked || ttl != 40*time.Second {
+		t.Errorf("expected 40s of block left, got blocked=%v ttl=%v", blocked, ttl)
+	}
+
+	clock.Advance(40 * time.Second)
+	if blocked, err := store.IsBlocked(ctx, "ip:10.0.0.1"); err != nil || blocked {
+		t.Errorf("expected the block to expire, got blocked=%v (%v)", blocked, err)
+	}
+}
+
+func TestMemoryStore_ParallelIncrementsAreExact(t *testing.T) {
+	store, _ := newTestMemoryStore(t, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := store.Increment(context.Background(), "ip:10.0.0.1", 1); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count, err := store.Increment(context.Background(), "ip:10.0.0.1", 1); err != nil || count != 1001 {
+		t.Errorf("expected count 1001 after 1000 parallel increments, got %d (%v)", count, err)
+	}
+}
+
+func TestMemoryStore_CanceledContext(t *testing.T) {
+	store, _ := newTestMemoryStore(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.Increment(ctx, "ip:10.0.0.1", 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -3,28 +3,212 @@ package limiter
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+var slidingLogScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	return {count + 1, tonumber(oldest[2])}
+end
+return {count + 1, now}
+`)
+
+var slidingCounterScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local limit = tonumber(ARGV[1])
+local remaining = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local estimate = math.floor(previous * remaining / window) + current
+if estimate < limit then
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], 2 * window)
+end
+
+return estimate + 1
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) / interval)
+end
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'ts', string.format('%d', math.max(now, ts)))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * interval / 1000) + 1000)
+
+return {allowed, string.format('%.6f', tokens)}
+`)
+
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local newTat = tat + interval * cost
+local allowAt = newTat - tolerance
+if now < allowAt then
+	return {0, allowAt - now, tat - now}
+end
+
+if newTat <= now then
+	redis.call('DEL', KEYS[1])
+	return {1, 0, 0}
+end
+
+redis.call('SET', KEYS[1], string.format('%d', newTat), 'PX', math.ceil((newTat - now) / 1000))
+return {1, 0, newTat - now}
+`)
+
+var acquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local inFlight = redis.call('ZCARD', KEYS[1])
+if inFlight >= limit then
+	return {0, inFlight}
+end
+
+redis.call('ZADD', KEYS[1], now + ttl, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {1, inFlight + 1}
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	redis.call('ZADD', KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[2]), ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var checkAndIncrementScript = redis.NewScript(`
+local blockTTL = redis.call('PTTL', KEYS[2])
+if blockTTL ~= -2 then
+	return {0, 1, math.max(blockTTL, 0)}
+end
+
+local count = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+
+local limit = tonumber(ARGV[2])
+local blockMs = tonumber(ARGV[3])
+if count > limit and blockMs > 0 then
+	redis.call('SET', KEYS[2], 1, 'PX', blockMs)
+	return {count, 1, blockMs}
+end
+
+return {count, 0, 0}
+`)
+
+// checkAndIncrementLimitsScript returns count, blocked and block TTL for every limit.
+var checkAndIncrementLimitsScript = redis.NewScript(`
+local n = #KEYS / 2
+local result = {}
+local blocked = false
+for i = 1, n do
+	local blockTTL = redis.call('PTTL', KEYS[2 * i])
+	if blockTTL ~= -2 then
+		blocked = true
+		result[3 * i - 2], result[3 * i - 1], result[3 * i] = 0, 1, math.max(blockTTL, 0)
+	else
+		result[3 * i - 2], result[3 * i - 1], result[3 * i] = 0, 0, 0
+	end
+end
+if blocked then
+	return result
+end
+
+local exceeded = false
+for i = 1, n do
+	local count = tonumber(redis.call('GET', KEYS[2 * i - 1]) or '0') + 1
+	result[3 * i - 2] = count
+	if count > tonumber(ARGV[3 * i - 1]) then
+		exceeded = true
+	end
+end
+
+for i = 1, n do
+	if not exceeded then
+		redis.call('INCR', KEYS[2 * i - 1])
+		redis.call('EXPIRE', KEYS[2 * i - 1], ARGV[3 * i - 2])
+	else
+		local blockMs = tonumber(ARGV[3 * i])
+		if result[3 * i - 2] > tonumber(ARGV[3 * i - 1]) and blockMs > 0 then
+			redis.call('SET', KEYS[2 * i], 1, 'PX', blockMs)
+			result[3 * i - 1], result[3 * i] = 1, blockMs
+		end
+	end
+end
+
+return result
+`)
+
 type RedisStore struct {
 	client *redis.Client
+	now    func() time.Time
 }
 
 func NewRedisStore(client *redis.Client) *RedisStore {
 	return &RedisStore{
 		client: client,
+		now:    time.Now,
 	}
 }
 
+// SetClock makes the store read the current time from now, e.g. a test clock.
+func (r *RedisStore) SetClock(now func() time.Time) {
+	r.now = now
+}
+
 func (r *RedisStore) Increment(ctx context.Context, key string, windowSec int) (int64, error) {
-	now := time.Now().Unix()
-	windowKey := fmt.Sprintf("ratelimit:%s:%d", key, now)
+	start, ttl := fixedWindow(r.now(), windowSec)
+	windowKey := fmt.Sprintf("ratelimit:%s:%d", key, start)
 
 	pipe := r.client.Pipeline()
 	incrCmd := pipe.Incr(ctx, windowKey)
-	pipe.Expire(ctx, windowKey, time.Duration(windowSec+1)*time.Second)
+	pipe.Expire(ctx, windowKey, ttl)
 
 	_, err := pipe.Exec(ctx)
 	if err != nil {
@@ -52,3 +236,162 @@ func (r *RedisStore) Block(ctx context.Context, key string, duration time.Durati
 	}
 	return nil
 }
+
+func (r *RedisStore) BlockTTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	blockedKey := fmt.Sprintf("ratelimit:blocked:%s", key)
+	ttl, err := r.client.PTTL(ctx, blockedKey).Result()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get block ttl: %w", err)
+	}
+
+	switch {
+	case ttl == -2:
+		return 0, false, nil
+	case ttl < 0:
+		return 0, true, nil
+	default:
+		return ttl, true, nil
+	}
+}
+
+func (r *RedisStore) SlidingLog(ctx context.Context, key string, limit int, window time.Duration) (int64, time.Time, error) {
+	now := r.now()
+	logKey := fmt.Sprintf("ratelimit:log:%s", key)
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	res, err := slidingLogScript.Run(ctx, r.client, []string{logKey},
+		now.UnixMilli(), window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to update sliding log: %w", err)
+	}
+
+	return res[0], time.UnixMilli(res[1]), nil
+}
+
+func (r *RedisStore) SlidingCounter(ctx context.Context, key string, limit int, window time.Duration) (int64, error) {
+	now := r.now().UnixMilli()
+	windowMs := window.Milliseconds()
+	index := now / windowMs
+	remaining := windowMs - now%windowMs
+
+	currentKey := fmt.Sprintf("ratelimit:swc:%s:%d", key, index)
+	previousKey := fmt.Sprintf("ratelimit:swc:%s:%d", key, index-1)
+
+	count, err := slidingCounterScript.Run(ctx, r.client, []string{currentKey, previousKey},
+		limit, remaining, windowMs).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to update sliding counter: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *RedisStore) TakeTokens(ctx context.Context, key string, capacity int, interval time.Duration, cost int) (bool, float64, error) {
+	bucketKey := fmt.Sprintf("ratelimit:bucket:%s", key)
+
+	res, err := tokenBucketScript.Run(ctx, r.client, []string{bucketKey},
+		capacity, interval.Microseconds(), cost, r.now().UnixMicro()).Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take tokens: %w", err)
+	}
+
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to parse token count: %w", err)
+	}
+
+	return allowed == 1, tokens, nil
+}
+
+func (r *RedisStore) GCRA(ctx context.Context, key string, interval, tolerance time.Duration, cost int) (bool, time.Duration, time.Duration, error) {
+	gcraKey := fmt.Sprintf("ratelimit:gcra:%s", key)
+
+	res, err := gcraScript.Run(ctx, r.client, []string{gcraKey},
+		interval.Microseconds(), tolerance.Microseconds(), cost, r.now().UnixMicro()).Int64Slice()
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("failed to apply gcra: %w", err)
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Microsecond, time.Duration(res[2]) * time.Microsecond, nil
+}
+
+func (r *RedisStore) Acquire(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (bool, int64, error) {
+	inFlightKey := fmt.Sprintf("ratelimit:inflight:%s", key)
+
+	res, err := acquireScript.Run(ctx, r.client, []string{inFlightKey},
+		r.now().UnixMilli(), ttl.Milliseconds(), limit, leaseID).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to acquire concurrency lease: %w", err)
+	}
+
+	return res[0] == 1, res[1], nil
+}
+
+func (r *RedisStore) Extend(ctx context.Context, key string, leaseID string, ttl time.Duration) error {
+	inFlightKey := fmt.Sprintf("ratelimit:inflight:%s", key)
+
+	err := extendScript.Run(ctx, r.client, []string{inFlightKey},
+		r.now().UnixMilli(), ttl.Milliseconds(), leaseID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to extend concurrency lease: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) Release(ctx context.Context, key string, leaseID string) error {
+	inFlightKey := fmt.Sprintf("ratelimit:inflight:%s", key)
+
+	err := r.client.ZRem(ctx, inFlightKey, leaseID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to release concurrency lease: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) CheckAndIncrement(ctx context.Context, key string, windowSec int, limit int, blockDuration time.Duration) (CheckResult, error) {
+	start, ttl := fixedWindow(r.now(), windowSec)
+	windowKey := fmt.Sprintf("ratelimit:%s:%d", key, start)
+	blockedKey := fmt.Sprintf("ratelimit:blocked:%s", key)
+
+	res, err := checkAndIncrementScript.Run(ctx, r.client, []string{windowKey, blockedKey},
+		int64(ttl.Seconds()), limit, blockDuration.Milliseconds()).Int64Slice()
+	if err != nil {
+		return CheckResult{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+
+	return CheckResult{
+		Count:    res[0],
+		Blocked:  res[1] == 1,
+		BlockTTL: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
+
+func (r *RedisStore) CheckAndIncrementLimits(ctx context.Context, checks []LimitCheck) ([]CheckResult, error) {
+	now := r.now()
+	keys := make([]string, 0, 2*len(checks))
+	args := make([]any, 0, 3*len(checks))
+	for _, check := range checks {
+		start, ttl := fixedWindow(now, check.WindowSec)
+		keys = append(keys,
+			fmt.Sprintf("ratelimit:%s:%d", check.Key, start),
+			fmt.Sprintf("ratelimit:blocked:%s", check.Key))
+		args = append(args, int64(ttl.Seconds()), check.Limit, check.BlockDuration.Milliseconds())
+	}
+
+	res, err := checkAndIncrementLimitsScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limits: %w", err)
+	}
+
+	results := make([]CheckResult, len(checks))
+	for i := range results {
+		results[i] = CheckResult{
+			Count:    res[3*i],
+			Blocked:  res[3*i+1] == 1,
+			BlockTTL: time.Duration(res[3*i+2]) * time.Millisecond,
+		}
+	}
+	return results, nil
+}
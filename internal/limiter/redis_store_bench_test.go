@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type roundTripCounter struct {
+	count atomic.Int64
+}
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.count.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.count.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+// benchmarkAlgorithm reports the Redis round trips per request and the live keys left.
+func benchmarkAlgorithm(b *testing.B, opts ...Option) {
+	mr := miniredis.RunT(b)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	b.Cleanup(func() { client.Close() })
+
+	counter := &roundTripCounter{}
+	client.AddHook(counter)
+
+	clock := &testClock{now: testEpoch}
+	store := NewRedisStore(client)
+	store.now = clock.Now
+	rl := NewRateLimiter(store, 10, 5*time.Minute, nil, opts...)
+	rl.now = clock.Now
+
+	ips := make([]string, 1000)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, byte(i>>8), byte(i)).String()
+	}
+
+	ctx := context.Background()
+	if _, err := rl.Check(ctx, ips[0], ""); err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	counter.count.Store(0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rl.Check(ctx, ips[i%len(ips)], ""); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		clock.Advance(time.Millisecond)
+		mr.FastForward(time.Millisecond)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(counter.count.Load())/float64(b.N), "roundtrips/op")
+	b.ReportMetric(float64(len(mr.Keys())), "keys")
+}
+
+func BenchmarkRedisStore_FixedWindow(b *testing.B) {
+	benchmarkAlgorithm(b)
+}
+
+func BenchmarkRedisStore_GCRA(b *testing.B) {
+	benchmarkAlgorithm(b, WithIPAlgorithm(GCRA))
+}
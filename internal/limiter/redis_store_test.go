@@ -0,0 +1,678 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testEpoch is aligned to the minute so window boundaries are predictable.
+var testEpoch = time.Unix(1699999980, 0)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *testClock) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	clock := &testClock{now: testEpoch}
+	store := NewRedisStore(client)
+	store.now = clock.Now
+	return store, clock
+}
+
+func sendBurst(t *testing.T, rl *RateLimiter, n int) int {
+	t.Helper()
+
+	allowed := 0
+	for i := 0; i < n; i++ {
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRedisStore_FixedWindow_AllowsBoundaryBurst(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 5, 0, nil)
+
+	clock.Advance(900 * time.Millisecond)
+	if got := sendBurst(t, rl, 5); got != 5 {
+		t.Fatalf("expected 5 requests allowed before the boundary, got %d", got)
+	}
+
+	clock.Advance(200 * time.Millisecond)
+	if got := sendBurst(t, rl, 5); got != 5 {
+		t.Errorf("expected fixed window to allow 5 more requests after the boundary, got %d", got)
+	}
+}
+
+func TestRedisStore_SlidingLog_RejectsBoundaryBurst(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 5, 0, nil, WithIPAlgorithm(SlidingWindowLog))
+
+	clock.Advance(900 * time.Millisecond)
+	if got := sendBurst(t, rl, 5); got != 5 {
+		t.Fatalf("expected 5 requests allowed before the boundary, got %d", got)
+	}
+
+	clock.Advance(200 * time.Millisecond)
+	if got := sendBurst(t, rl, 5); got != 0 {
+		t.Errorf("expected sliding log to reject the burst across the boundary, got %d allowed", got)
+	}
+
+	clock.Advance(900 * time.Millisecond)
+	if got := sendBurst(t, rl, 5); got != 5 {
+		t.Errorf("expected 5 requests allowed once the window has slid past, got %d", got)
+	}
+}
+
+func TestRedisStore_SlidingLog_PartialExpiry(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := store.SlidingLog(ctx, "ip:1.1.1.1", 3, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clock.Advance(400 * time.Millisecond)
+	}
+
+	count, oldest, err := store.SlidingLog(ctx, "ip:1.1.1.1", 3, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected count 3 after the first entry expired, got %d", count)
+	}
+	if want := testEpoch.Add(400 * time.Millisecond); !oldest.Equal(want) {
+		t.Errorf("expected oldest entry at %v, got %v", want, oldest)
+	}
+}
+
+func TestRedisStore_SlidingLog_RejectedRequestsAreNotRecorded(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		if _, _, err := store.SlidingLog(ctx, "ip:1.1.1.1", 2, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	clock.Advance(time.Second)
+	count, _, err := store.SlidingLog(ctx, "ip:1.1.1.1", 2, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected rejected requests not to extend the log, got count %d", count)
+	}
+}
+
+func TestRedisStore_SlidingLog_ResetAndRetryAfter(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 2, 0, nil, WithIPAlgorithm(SlidingWindowLog))
+	rl.now = clock.Now
+
+	sendBurst(t, rl, 1)
+	clock.Advance(400 * time.Millisecond)
+	sendBurst(t, rl, 1)
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected request to be rejected")
+	}
+	if decision.RetryAfter != 600*time.Millisecond {
+		t.Errorf("expected retry-after 600ms until the oldest entry expires, got %v", decision.RetryAfter)
+	}
+}
+
+func TestRedisStore_SlidingCounter_WeightsPreviousWindow(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, 0, nil, WithIPAlgorithm(SlidingWindowCounter))
+	rl.now = clock.Now
+
+	clock.Advance(500 * time.Millisecond)
+	if got := sendBurst(t, rl, 12); got != 10 {
+		t.Fatalf("expected 10 requests allowed in the first window, got %d", got)
+	}
+
+	clock.Advance(time.Second)
+	if got := sendBurst(t, rl, 10); got != 5 {
+		t.Errorf("expected 5 requests allowed halfway through the next window, got %d", got)
+	}
+
+	clock.Advance(400 * time.Millisecond)
+	if got := sendBurst(t, rl, 10); got != 4 {
+		t.Errorf("expected 4 requests allowed with 10%% of the previous window remaining, got %d", got)
+	}
+}
+
+func TestRedisStore_SlidingCounter_ConfigurableWindow(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 3, 0, nil, WithIPAlgorithm(SlidingWindowCounter), WithIPWindow(time.Minute))
+	rl.now = clock.Now
+
+	if got := sendBurst(t, rl, 5); got != 3 {
+		t.Fatalf("expected 3 requests allowed, got %d", got)
+	}
+
+	clock.Advance(30 * time.Second)
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected request to be rejected within the same minute")
+	}
+	if decision.Window != time.Minute {
+		t.Errorf("expected window 1m, got %v", decision.Window)
+	}
+	if decision.RetryAfter != 30*time.Second {
+		t.Errorf("expected retry-after 30s until the window rolls over, got %v", decision.RetryAfter)
+	}
+}
+
+func TestRedisStore_SlidingLog_ConfigurableWindow(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	tokenConfigs := map[string]TokenConfig{
+		"abc123": {Limit: 2, Algorithm: SlidingWindowLog, Window: time.Minute},
+	}
+	rl := NewRateLimiter(store, 10, 0, tokenConfigs)
+	rl.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "abc123")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("request %d: expected request to be allowed", i+1)
+		}
+	}
+
+	clock.Advance(59 * time.Second)
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected request to be rejected within the one minute window")
+	}
+
+	clock.Advance(time.Second)
+	decision, err = rl.Check(context.Background(), "192.168.1.1", "abc123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected request to be allowed once the window has passed")
+	}
+}
+
+func TestRedisStore_TokenBucket_BurstThenSustainedRate(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	tokenConfigs := map[string]TokenConfig{
+		"partner": {Limit: 50, Window: time.Second, Burst: 200, Algorithm: TokenBucket},
+	}
+	rl := NewRateLimiter(store, 10, 0, tokenConfigs)
+	rl.now = clock.Now
+
+	allowed := 0
+	for i := 0; i < 250; i++ {
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "partner")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 200 {
+		t.Fatalf("expected a burst of 200 requests, got %d", allowed)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	allowed = 0
+	for i := 0; i < 10; i++ {
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "partner")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("expected 5 tokens refilled after 100ms at 50 rps, got %d", allowed)
+	}
+}
+
+func TestRedisStore_TokenBucket_Decision(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	tokenConfigs := map[string]TokenConfig{
+		"partner": {Limit: 10, Window: time.Second, Burst: 20, Cost: 4, Algorithm: TokenBucket, BlockDuration: time.Minute},
+	}
+	rl := NewRateLimiter(store, 10, 0, tokenConfigs)
+	rl.now = clock.Now
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "partner")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Fatal("expected request to be allowed")
+	}
+	if decision.Limit != 20 {
+		t.Errorf("expected limit to report the bucket capacity 20, got %d", decision.Limit)
+	}
+	if decision.Remaining != 16 {
+		t.Errorf("expected 16 tokens remaining, got %d", decision.Remaining)
+	}
+	if want := clock.Now().Add(400 * time.Millisecond); !decision.ResetAt.Equal(want) {
+		t.Errorf("expected bucket to be full again at %v, got %v", want, decision.ResetAt)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := rl.Check(context.Background(), "192.168.1.1", "partner"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	decision, err = rl.Check(context.Background(), "192.168.1.1", "partner")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected request to be rejected with an empty bucket")
+	}
+	if decision.RetryAfter != 400*time.Millisecond {
+		t.Errorf("expected retry-after 400ms to refill 4 tokens, got %v", decision.RetryAfter)
+	}
+
+	clock.Advance(400 * time.Millisecond)
+	decision, err = rl.Check(context.Background(), "192.168.1.1", "partner")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Error("expected request to be allowed after refill; token bucket must not block")
+	}
+}
+
+func TestRedisStore_SubMicrosecondInterval(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+
+	for _, algorithm := range []Algorithm{TokenBucket, GCRA, LeakyBucket} {
+		rl := NewRateLimiter(store, 2000000, 0, nil, WithIPAlgorithm(algorithm), WithRuleID(string(algorithm)))
+		rl.now = clock.Now
+
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", algorithm, err)
+		}
+		if !decision.Allowed {
+			t.Errorf("%s: expected request to be allowed at 2M rps", algorithm)
+		}
+	}
+}
+
+func TestRedisStore_TokenBucket_CapacityIsCapped(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if _, _, err := store.TakeTokens(ctx, "ip:1.1.1.1", 5, 100*time.Millisecond, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	_, tokens, err := store.TakeTokens(ctx, "ip:1.1.1.1", 5, 100*time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens != 4 {
+		t.Errorf("expected refill to stop at capacity, got %v tokens left", tokens)
+	}
+}
+
+func TestRedisStore_GCRA_BurstThenEvenlySpaced(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, 0, nil, WithIPAlgorithm(GCRA), WithIPBurst(3))
+	rl.now = clock.Now
+
+	if got := sendBurst(t, rl, 5); got != 3 {
+		t.Fatalf("expected a burst of 3 requests, got %d", got)
+	}
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected request to be rejected after the burst")
+	}
+	if decision.RetryAfter != 100*time.Millisecond {
+		t.Errorf("expected exact retry-after of one emission interval (100ms), got %v", decision.RetryAfter)
+	}
+
+	clock.Advance(99 * time.Millisecond)
+	if got := sendBurst(t, rl, 1); got != 0 {
+		t.Error("expected request to be rejected just before the retry-after elapses")
+	}
+
+	clock.Advance(time.Millisecond)
+	if got := sendBurst(t, rl, 2); got != 1 {
+		t.Errorf("expected exactly one request once the retry-after elapsed, got %d", got)
+	}
+}
+
+func TestRedisStore_GCRA_Decision(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 5, 5*time.Minute, nil, WithIPAlgorithm(GCRA))
+	rl.now = clock.Now
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed {
+		t.Fatal("expected request to be allowed")
+	}
+	if decision.Limit != 5 {
+		t.Errorf("expected limit 5, got %d", decision.Limit)
+	}
+	if decision.Remaining != 4 {
+		t.Errorf("expected remaining 4, got %d", decision.Remaining)
+	}
+	if want := clock.Now().Add(200 * time.Millisecond); !decision.ResetAt.Equal(want) {
+		t.Errorf("expected reset at %v, got %v", want, decision.ResetAt)
+	}
+}
+
+func TestRedisStore_GCRA_SingleKeyPerIdentity(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	rl := NewRateLimiter(NewRedisStore(client), 1, 5*time.Minute, nil, WithIPAlgorithm(GCRA))
+
+	for i := 0; i < 5; i++ {
+		if _, err := rl.Check(context.Background(), "192.168.1.1", ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	keys := mr.Keys()
+	if len(keys) != 1 || keys[0] != "ratelimit:gcra:ip:192.168.1.1" {
+		t.Errorf("expected a single gcra key and no block key, got %v", keys)
+	}
+}
+
+func TestRedisStore_LeakyBucket_QueuesRequests(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, 5*time.Minute, nil, WithIPAlgorithm(LeakyBucket), WithIPBurst(3))
+	rl.now = clock.Now
+
+	for i := 0; i < 4; i++ {
+		decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("request %d: expected request to be queued", i+1)
+		}
+		if want := time.Duration(i) * 100 * time.Millisecond; decision.Delay != want {
+			t.Errorf("request %d: expected delay %v, got %v", i+1, want, decision.Delay)
+		}
+		if want := 3 - i; decision.Remaining != want {
+			t.Errorf("request %d: expected %d queue slots left, got %d", i+1, want, decision.Remaining)
+		}
+	}
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Fatal("expected request to be rejected when the queue is full")
+	}
+	if decision.RetryAfter != 100*time.Millisecond {
+		t.Errorf("expected retry-after 100ms until a slot frees, got %v", decision.RetryAfter)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	decision, err = rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed || decision.Delay != 300*time.Millisecond {
+		t.Errorf("expected request to be queued with 300ms delay, got allowed=%v delay=%v", decision.Allowed, decision.Delay)
+	}
+}
+
+func TestRateLimiter_LeakyBucket_Refund(t *testing.T) {
+	redisStore, redisClock := newTestRedisStore(t)
+	memoryStore, memoryClock := newTestMemoryStore(t, 0)
+	stores := map[string]struct {
+		store Store
+		clock *testClock
+	}{
+		"redis":  {redisStore, redisClock},
+		"memory": {memoryStore, memoryClock},
+	}
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			rl := NewRateLimiter(s.store, 10, 0, nil, WithIPAlgorithm(LeakyBucket), WithIPBurst(3))
+			rl.now = s.clock.Now
+			id := Identity{IP: "192.168.1.1"}
+
+			for i := 0; i < 4; i++ {
+				if _, err := rl.CheckIdentity(context.Background(), id); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if err := rl.Refund(context.Background(), id); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			decision, err := rl.CheckIdentity(context.Background(), id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !decision.Allowed || decision.Delay != 300*time.Millisecond {
+				t.Errorf("expected the refunded slot to be queued with 300ms delay, got allowed=%v delay=%v", decision.Allowed, decision.Delay)
+			}
+
+			// Refunding an idle bucket leaves it empty.
+			s.clock.Advance(time.Second)
+			if err := rl.Refund(context.Background(), id); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			decision, err = rl.CheckIdentity(context.Background(), id)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Delay != 0 || decision.Remaining != 3 {
+				t.Errorf("expected an empty queue after refunding an idle bucket, got delay=%v remaining=%d", decision.Delay, decision.Remaining)
+			}
+		})
+	}
+}
+
+func TestRedisStore_LeakyBucket_BoundedByMaxWait(t *testing.T) {
+	store, clock := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, 0, nil, WithIPAlgorithm(LeakyBucket), WithIPBurst(100), WithIPMaxWait(250*time.Millisecond))
+	rl.now = clock.Now
+
+	if got := sendBurst(t, rl, 10); got != 3 {
+		t.Errorf("expected 3 requests queued within 250ms, got %d", got)
+	}
+}
+
+func TestRedisStore_LeakyBucket_BoundedByDeadline(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, 0, nil, WithIPAlgorithm(LeakyBucket), WithIPBurst(100))
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Hour))
+	defer cancel()
+	rl.now = func() time.Time {
+		deadline, _ := ctx.Deadline()
+		return deadline.Add(-150 * time.Millisecond)
+	}
+	store.now = rl.now
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		decision, err := rl.Check(ctx, "192.168.1.1", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected 2 requests queued before the deadline, got %d", allowed)
+	}
+}
+
+func TestRedisStore_CheckAndIncrement_ExactlyLimitUnderParallelLoad(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	rl := NewRateLimiter(store, 10, time.Minute, nil)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if decision.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 10 {
+		t.Errorf("expected exactly 10 requests allowed, got %d", got)
+	}
+
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed {
+		t.Error("expected key to be blocked")
+	}
+	if decision.RetryAfter <= 0 || decision.RetryAfter > time.Minute {
+		t.Errorf("expected retry-after from the block ttl, got %v", decision.RetryAfter)
+	}
+}
+
+func TestRedisStore_CheckAndIncrement_SingleRoundTrip(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	counter := &roundTripCounter{}
+	store.client.AddHook(counter)
+	rl := NewRateLimiter(store, 2, time.Minute, nil)
+
+	if _, err := rl.Check(context.Background(), "10.0.0.1", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counter.count.Store(0)
+
+	for i := 0; i < 5; i++ {
+		if _, err := rl.Check(context.Background(), "192.168.1.1", ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := counter.count.Load(); got != 5 {
+		t.Errorf("expected one round trip per check, got %d for 5 checks", got)
+	}
+}
+
+func TestRedisStore_CheckAndIncrementLimits_SingleRoundTrip(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	counter := &roundTripCounter{}
+	store.client.AddHook(counter)
+	rl := NewRateLimiter(store, 2, time.Minute, map[string]TokenConfig{
+		"abc": {
+			Limit: 10,
+			Limits: []Limit{
+				{Limit: 300, Window: time.Minute},
+				{Limit: 10000, Window: 24 * time.Hour, BlockDuration: time.Hour},
+			},
+		},
+	})
+
+	if _, err := rl.Check(context.Background(), "10.0.0.1", "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counter.count.Store(0)
+
+	for i := 0; i < 5; i++ {
+		if _, err := rl.Check(context.Background(), "192.168.1.1", "abc"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := counter.count.Load(); got != 5 {
+		t.Errorf("expected one round trip per check of all limits, got %d for 5 checks", got)
+	}
+}
+
+func TestRedisStore_CheckAndIncrement_BlockedKeyIsNotCounted(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	ctx := context.Background()
+
+	if err := store.Block(ctx, "ip:1.1.1.1", 30*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := store.CheckAndIncrement(ctx, "ip:1.1.1.1", 1, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Blocked {
+		t.Error("expected key to be reported as blocked")
+	}
+	if result.BlockTTL != 30*time.Second {
+		t.Errorf("expected block ttl 30s, got %v", result.BlockTTL)
+	}
+	if result.Count != 0 {
+		t.Errorf("expected blocked key not to be counted, got %d", result.Count)
+	}
+}
@@ -0,0 +1,221 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisTokenPrefix  = "ratelimit:token:"
+	redisTokenChannel = "ratelimit:tokens"
+
+	defaultTokenCacheTTL      = time.Minute
+	defaultTokenCacheSize     = 100000
+	defaultTokenMissCacheSize = 1000
+)
+
+// RedisTokenProvider serves token configs stored in Redis hashes at ratelimit:token:<token>.
+type RedisTokenProvider struct {
+	client        *redis.Client
+	cacheTTL      time.Duration
+	cacheSize     int
+	missCacheSize int
+	now           func() time.Time
+
+	mu         sync.RWMutex
+	cache      map[string]cachedToken
+	misses     map[string]cachedToken
+	generation uint64
+}
+
+type cachedToken struct {
+	config    TokenConfig
+	found     bool
+	expiresAt time.Time
+	cachedAt  time.Time
+}
+
+type RedisTokenOption func(*RedisTokenProvider)
+
+// WithTokenCacheTTL bounds how long a cached lookup is used.
+func WithTokenCacheTTL(ttl time.Duration) RedisTokenOption {
+	return func(p *RedisTokenProvider) {
+		p.cacheTTL = ttl
+	}
+}
+
+// WithTokenCacheSize bounds the number of cached known tokens.
+func WithTokenCacheSize(size int) RedisTokenOption {
+	return func(p *RedisTokenProvider) {
+		p.cacheSize = size
+	}
+}
+
+func NewRedisTokenProvider(client *redis.Client, opts ...RedisTokenOption) *RedisTokenProvider {
+	p := &RedisTokenProvider{
+		client:        client,
+		cacheTTL:      defaultTokenCacheTTL,
+		cacheSize:     defaultTokenCacheSize,
+		missCacheSize: defaultTokenMissCacheSize,
+		now:           time.Now,
+		cache:         make(map[string]cachedToken),
+		misses:        make(map[string]cachedToken),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *RedisTokenProvider) TokenConfig(ctx context.Context, name string) (TokenConfig, bool, error) {
+	now := p.now()
+
+	p.mu.RLock()
+	entry, cached := p.cache[name]
+	if !cached {
+		entry, cached = p.misses[name]
+	}
+	generation := p.generation
+	p.mu.RUnlock()
+
+	if !cached || now.Sub(entry.cachedAt) >= p.cacheTTL {
+		var err error
+		entry, err = p.fetch(ctx, name)
+		if err != nil {
+			return TokenConfig{}, false, err
+		}
+		entry.cachedAt = now
+		p.store(name, entry, generation)
+	}
+
+	if !entry.found || (!entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)) {
+		return TokenConfig{}, false, nil
+	}
+	return entry.config, true, nil
+}
+
+func (p *RedisTokenProvider) fetch(ctx context.Context, name string) (cachedToken, error) {
+	fields, err := p.client.HGetAll(ctx, redisTokenPrefix+name).Result()
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("failed to get token config: %w", err)
+	}
+	if len(fields) == 0 {
+		return cachedToken{}, nil
+	}
+
+	entry := cachedToken{found: true}
+	for field, value := range fields {
+		switch field {
+		case "limit":
+			entry.config.Limit, err = strconv.Atoi(value)
+		case "window":
+			entry.config.Window, err = parseRedisDuration(value)
+		case "block":
+			entry.config.BlockDuration, err = parseRedisDuration(value)
+		case "plan":
+			entry.config.Plan = value
+		case "expires_at":
+			var unix int64
+			unix, err = strconv.ParseInt(value, 10, 64)
+			entry.expiresAt = time.Unix(unix, 0)
+		}
+		if err != nil {
+			return cachedToken{}, fmt.Errorf("invalid %s in token config: %q", field, value)
+		}
+	}
+	// Tokens of a plan run the plan's algorithm.
+	if entry.config.Plan == "" {
+		entry.config.Algorithm = FixedWindow
+	}
+	entry.found = entry.config.Limit > 0 || entry.config.Plan != ""
+	return entry, nil
+}
+
+// store caches a lookup unless an invalidation arrived since generation was read.
+func (p *RedisTokenProvider) store(name string, entry cachedToken, generation uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.generation != generation {
+		return
+	}
+	if entry.found {
+		delete(p.misses, name)
+		cacheEntry(p.cache, p.cacheSize, name, entry)
+	} else {
+		delete(p.cache, name)
+		cacheEntry(p.misses, p.missCacheSize, name, entry)
+	}
+}
+
+func cacheEntry(cache map[string]cachedToken, size int, name string, entry cachedToken) {
+	if _, exists := cache[name]; !exists && len(cache) >= size {
+		for evicted := range cache {
+			delete(cache, evicted)
+			break
+		}
+	}
+	cache[name] = entry
+}
+
+// drop removes name from the cache, or every entry when name is empty or "*".
+func (p *RedisTokenProvider) drop(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.generation++
+	if name == "" || name == "*" {
+		clear(p.cache)
+		clear(p.misses)
+		return
+	}
+	delete(p.cache, name)
+	delete(p.misses, name)
+}
+
+// Invalidate tells every instance running Listen to drop name from its cache.
+func (p *RedisTokenProvider) Invalidate(ctx context.Context, name string) error {
+	p.drop(name)
+	if err := p.client.Publish(ctx, redisTokenChannel, name).Err(); err != nil {
+		return fmt.Errorf("failed to publish token invalidation: %w", err)
+	}
+	return nil
+}
+
+// Listen drops the tokens published on the invalidation channel from the cache.
+func (p *RedisTokenProvider) Listen(ctx context.Context) {
+	pubsub := p.client.Subscribe(ctx, redisTokenChannel)
+	defer pubsub.Close()
+
+	messages := pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				if msg.Kind == "subscribe" {
+					p.drop("*")
+				}
+			case *redis.Message:
+				p.drop(msg.Payload)
+			}
+		}
+	}
+}
+
+func parseRedisDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
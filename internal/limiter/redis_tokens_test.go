@@ -0,0 +1,236 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisTokenProvider(t *testing.T, opts ...RedisTokenOption) (*RedisTokenProvider, *miniredis.Miniredis, *testClock) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	clock := &testClock{now: testEpoch}
+	p := NewRedisTokenProvider(client, opts...)
+	p.now = clock.Now
+	return p, mr, clock
+}
+
+func lookupToken(t *testing.T, p TokenProvider, name string) (TokenConfig, bool) {
+	t.Helper()
+	config, found, err := p.TokenConfig(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return config, found
+}
+
+func TestRedisTokenProvider_TokenConfig(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t)
+	mr.HSet("ratelimit:token:abc123", "limit", "100", "window", "60", "block", "5m")
+
+	config, found := lookupToken(t, p, "abc123")
+	if !found {
+		t.Fatal("expected abc123 to be found")
+	}
+	if config.Limit != 100 || config.Window != time.Minute || config.BlockDuration != 5*time.Minute || config.Algorithm != FixedWindow {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	if _, found := lookupToken(t, p, "unknown"); found {
+		t.Error("expected unknown token not to be found")
+	}
+
+	mr.HSet("ratelimit:token:nolimit", "window", "60")
+	if _, found := lookupToken(t, p, "nolimit"); found {
+		t.Error("expected a token without limit or plan not to be found")
+	}
+
+	mr.HSet("ratelimit:token:broken", "limit", "many")
+	if _, _, err := p.TokenConfig(context.Background(), "broken"); err == nil {
+		t.Error("expected error for an invalid limit")
+	}
+}
+
+func TestRedisTokenProvider_Cache(t *testing.T) {
+	p, mr, clock := newTestRedisTokenProvider(t, WithTokenCacheTTL(time.Minute))
+	mr.HSet("ratelimit:token:abc123", "limit", "100")
+	lookupToken(t, p, "abc123")
+	lookupToken(t, p, "new")
+
+	mr.HSet("ratelimit:token:abc123", "limit", "200")
+	mr.HSet("ratelimit:token:new", "limit", "10")
+	if config, _ := lookupToken(t, p, "abc123"); config.Limit != 100 {
+		t.Errorf("expected the cached limit 100, got %d", config.Limit)
+	}
+	if _, found := lookupToken(t, p, "new"); found {
+		t.Error("expected the lookup of an unknown token to be cached")
+	}
+
+	if err := p.Invalidate(context.Background(), "abc123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config, _ := lookupToken(t, p, "abc123"); config.Limit != 200 {
+		t.Errorf("expected limit 200 after invalidation, got %d", config.Limit)
+	}
+
+	clock.Advance(time.Minute)
+	if _, found := lookupToken(t, p, "new"); !found {
+		t.Error("expected the cached lookup to expire after the cache TTL")
+	}
+}
+
+func TestRedisTokenProvider_CacheSize(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t, WithTokenCacheSize(2))
+	for i := range 5 {
+		mr.HSet("ratelimit:token:t"+strconv.Itoa(i), "limit", "10")
+		lookupToken(t, p, "t"+strconv.Itoa(i))
+	}
+
+	if len(p.cache) != 2 {
+		t.Errorf("expected the cache to hold 2 lookups, got %d", len(p.cache))
+	}
+}
+
+func TestRedisTokenProvider_UnknownTokensKeepKnownOnesCached(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t, WithTokenCacheSize(2))
+	mr.HSet("ratelimit:token:abc123", "limit", "100")
+	lookupToken(t, p, "abc123")
+
+	for i := range 2 * defaultTokenMissCacheSize {
+		lookupToken(t, p, "random-"+strconv.Itoa(i))
+	}
+	if len(p.misses) > defaultTokenMissCacheSize {
+		t.Errorf("expected at most %d cached unknown tokens, got %d", defaultTokenMissCacheSize, len(p.misses))
+	}
+
+	mr.HSet("ratelimit:token:abc123", "limit", "200")
+	if config, _ := lookupToken(t, p, "abc123"); config.Limit != 100 {
+		t.Errorf("expected abc123 to stay cached with limit 100, got %d", config.Limit)
+	}
+}
+
+func TestRedisTokenProvider_Expiry(t *testing.T) {
+	p, mr, clock := newTestRedisTokenProvider(t)
+	mr.HSet("ratelimit:token:abc123", "limit", "100", "expires_at", strconv.FormatInt(testEpoch.Add(30*time.Second).Unix(), 10))
+
+	if _, found := lookupToken(t, p, "abc123"); !found {
+		t.Fatal("expected abc123 to be found before it expires")
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, found := lookupToken(t, p, "abc123"); found {
+		t.Error("expected abc123 not to be found once expired, even if cached")
+	}
+}
+
+func TestRedisTokenProvider_Listen(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t)
+	mr.HSet("ratelimit:token:abc123", "limit", "100")
+	mr.HSet("ratelimit:token:xyz789", "limit", "50")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Listen(ctx)
+
+	// Listen drops the whole cache once subscribed.
+	subscribed := func() bool {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		return p.generation > 0
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !subscribed() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected Listen to subscribe to the invalidation channel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	lookupToken(t, p, "abc123")
+	lookupToken(t, p, "xyz789")
+	mr.HSet("ratelimit:token:abc123", "limit", "200")
+	mr.HSet("ratelimit:token:xyz789", "limit", "60")
+
+	// Another instance publishes the change.
+	mr.Publish(redisTokenChannel, "abc123")
+
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		if config, _ := lookupToken(t, p, "abc123"); config.Limit == 200 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the published token to be dropped from the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if config, _ := lookupToken(t, p, "xyz789"); config.Limit != 50 {
+		t.Errorf("expected other tokens to stay cached, got limit %d", config.Limit)
+	}
+}
+
+func TestRateLimiter_RedisTokenProvider(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t)
+	store, clock := newTestMemoryStore(t, 0)
+	static := StaticTokens{"pro": {Limit: 5, Algorithm: FixedWindow, Window: time.Minute}}
+	rl := NewRateLimiter(store, 1, 0, nil, WithTokenProvider(TokenProviders{static, ResolvePlans(p, static)}))
+	rl.now = clock.Now
+
+	mr.HSet("ratelimit:token:abc123", "plan", "pro", "limit", "3")
+	mr.HSet("ratelimit:token:xyz789", "plan", "pro")
+
+	send := func(token string) int {
+		t.Helper()
+		allowed := 0
+		for range 10 {
+			decision, err := rl.Check(context.Background(), "192.168.1.1", token)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	if allowed := send("abc123"); allowed != 3 {
+		t.Errorf("expected the token's own limit of 3 to override the plan's, got %d allowed", allowed)
+	}
+	if allowed := send("xyz789"); allowed != 5 {
+		t.Errorf("expected the plan's limit of 5, got %d allowed", allowed)
+	}
+	if allowed := send("unknown"); allowed != 1 {
+		t.Errorf("expected unknown tokens to get the IP limit of 1, got %d allowed", allowed)
+	}
+}
+
+func TestRedisTokenProvider_PlanAlgorithm(t *testing.T) {
+	p, mr, _ := newTestRedisTokenProvider(t)
+	plans := StaticTokens{"pro": {Limit: 100, Algorithm: TokenBucket, Burst: 50}}
+	tokens := ResolvePlans(p, plans)
+
+	mr.HSet("ratelimit:token:abc123", "plan", "pro")
+	mr.HSet("ratelimit:token:xyz789", "plan", "pro", "limit", "200")
+
+	for _, tt := range []struct {
+		token     string
+		wantLimit int
+	}{
+		{"abc123", 100},
+		{"xyz789", 200},
+	} {
+		config, found := lookupToken(t, tokens, tt.token)
+		if !found || config.Algorithm != TokenBucket || config.Burst != 50 || config.Limit != tt.wantLimit {
+			t.Errorf("%s: expected the plan's token bucket with limit %d, got %+v", tt.token, tt.wantLimit, config)
+		}
+	}
+}
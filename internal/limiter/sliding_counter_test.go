@@ -0,0 +1,52 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// counterStore is an in-process SlidingCounterStore.
+type counterStore struct {
+	mockStore
+	now    func() time.Time
+	counts map[string]int64
+}
+
+func (c *counterStore) SlidingCounter(ctx context.Context, key string, limit int, window time.Duration) (int64, error) {
+	now := c.now().UnixMilli()
+	windowMs := window.Milliseconds()
+	index := now / windowMs
+	remaining := windowMs - now%windowMs
+
+	currentKey := fmt.Sprintf("%s:%d", key, index)
+	previousKey := fmt.Sprintf("%s:%d", key, index-1)
+
+	estimate := c.counts[previousKey]*remaining/windowMs + c.counts[currentKey]
+	if estimate < int64(limit) {
+		c.counts[currentKey]++
+	}
+	return estimate + 1, nil
+}
+
+func TestRateLimiter_SlidingCounter_InProcessStore(t *testing.T) {
+	clock := &testClock{now: testEpoch}
+	store := &counterStore{now: clock.Now, counts: map[string]int64{}}
+	rl := NewRateLimiter(store, 10, 0, nil, WithIPAlgorithm(SlidingWindowCounter), WithIPWindow(time.Minute))
+	rl.now = clock.Now
+
+	clock.Advance(30 * time.Second)
+	if got := sendBurst(t, rl, 12); got != 10 {
+		t.Fatalf("expected 10 requests allowed in the first window, got %d", got)
+	}
+
+	clock.Advance(time.Minute)
+	decision, err := rl.Check(context.Background(), "192.168.1.1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decision.Allowed || decision.Window != time.Minute || decision.Remaining != 4 {
+		t.Errorf("expected half of the previous window to count, leaving 4 requests, got %+v", decision)
+	}
+}
@@ -6,9 +6,60 @@ import (
 )
 
 type Store interface {
+	// Increment counts a request in the current epoch aligned window and returns its count.
 	Increment(ctx context.Context, key string, windowSec int) (int64, error)
 
 	IsBlocked(ctx context.Context, key string) (bool, error)
 
 	Block(ctx context.Context, key string, duration time.Duration) error
 }
+
+// BlockTTLStore is implemented by stores that can report how long a key remains blocked.
+type BlockTTLStore interface {
+	BlockTTL(ctx context.Context, key string) (time.Duration, bool, error)
+}
+
+// SlidingLogStore is implemented by stores that support the sliding window log algorithm.
+type SlidingLogStore interface {
+	SlidingLog(ctx context.Context, key string, limit int, window time.Duration) (count int64, oldest time.Time, err error)
+}
+
+// SlidingCounterStore is implemented by stores that support the sliding window counter algorithm.
+type SlidingCounterStore interface {
+	SlidingCounter(ctx context.Context, key string, limit int, window time.Duration) (int64, error)
+}
+
+// TokenBucketStore is implemented by stores that support the token bucket algorithm.
+type TokenBucketStore interface {
+	TakeTokens(ctx context.Context, key string, capacity int, interval time.Duration, cost int) (allowed bool, tokens float64, err error)
+}
+
+// GCRAStore is implemented by stores that support the generic cell rate algorithm.
+type GCRAStore interface {
+	GCRA(ctx context.Context, key string, interval, tolerance time.Duration, cost int) (allowed bool, retryAfter, resetAfter time.Duration, err error)
+}
+
+// CheckResult is the outcome of an AtomicStore check.
+type CheckResult struct {
+	Count    int64
+	Blocked  bool
+	BlockTTL time.Duration
+}
+
+// AtomicStore is implemented by stores that can check, count and block a key atomically.
+type AtomicStore interface {
+	CheckAndIncrement(ctx context.Context, key string, windowSec int, limit int, blockDuration time.Duration) (CheckResult, error)
+}
+
+// LimitCheck is one limit of a MultiLimitStore check.
+type LimitCheck struct {
+	Key           string
+	WindowSec     int
+	Limit         int
+	BlockDuration time.Duration
+}
+
+// MultiLimitStore is implemented by stores that can apply several fixed window limits atomically.
+type MultiLimitStore interface {
+	CheckAndIncrementLimits(ctx context.Context, checks []LimitCheck) ([]CheckResult, error)
+}
@@ -0,0 +1,34 @@
+package limiter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter/storetest"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock *storetest.Clock) limiter.Store {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		clock.OnAdvance(mr.FastForward)
+
+		store := limiter.NewRedisStore(client)
+		store.SetClock(clock.Now)
+		return store
+	})
+}
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, clock *storetest.Clock) limiter.Store {
+		store := limiter.NewMemoryStore(0, time.Hour)
+		t.Cleanup(store.Close)
+
+		store.SetClock(clock.Now)
+		return store
+	})
+}
@@ -0,0 +1,574 @@
+// Package storetest provides a conformance suite for limiter.Store implementations.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+// Epoch is the time every Clock starts at.
+var Epoch = time.Unix(1699999980, 0)
+
+// Clock is a manual clock shared by the suite and the store under test.
+type Clock struct {
+	mu    sync.Mutex
+	now   time.Time
+	hooks []func(time.Duration)
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	hooks := c.hooks
+	c.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(d)
+	}
+}
+
+// OnAdvance registers fn to be called every time the clock moves, e.g. miniredis.FastForward.
+func (c *Clock) OnAdvance(fn func(time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, fn)
+}
+
+// Factory returns an empty store that reads the current time from clock.
+type Factory func(t *testing.T, clock *Clock) limiter.Store
+
+type harness struct {
+	store limiter.Store
+	clock *Clock
+}
+
+// Run verifies the stores returned by newStore, skipping optional interfaces they lack.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h harness)
+	}{
+		{"Increment", testIncrement},
+		{"IncrementWindowExpiry", testIncrementWindowExpiry},
+		{"IncrementLongWindow", testIncrementLongWindow},
+		{"IncrementParallel", testIncrementParallel},
+		{"Block", testBlock},
+		{"BlockTTL", testBlockTTL},
+		{"CanceledContext", testCanceledContext},
+		{"CheckAndIncrement", testCheckAndIncrement},
+		{"CheckAndIncrementParallel", testCheckAndIncrementParallel},
+		{"CheckAndIncrementLimits", testCheckAndIncrementLimits},
+		{"SlidingLog", testSlidingLog},
+		{"SlidingCounter", testSlidingCounter},
+		{"TokenBucket", testTokenBucket},
+		{"GCRA", testGCRA},
+		{"Concurrency", testConcurrency},
+		{"ConcurrencyParallel", testConcurrencyParallel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &Clock{now: Epoch}
+			tt.fn(t, harness{store: newStore(t, clock), clock: clock})
+		})
+	}
+}
+
+func capability[T any](t *testing.T, store limiter.Store) T {
+	t.Helper()
+
+	s, ok := store.(T)
+	if !ok {
+		var zero T
+		t.Skipf("store does not implement %T", &zero)
+	}
+	return s
+}
+
+func mustIncrement(t *testing.T, store limiter.Store, key string) int64 {
+	t.Helper()
+
+	count, err := store.Increment(context.Background(), key, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return count
+}
+
+func testIncrement(t *testing.T, h harness) {
+	for want := int64(1); want <= 3; want++ {
+		if count := mustIncrement(t, h.store, "ip:1.1.1.1"); count != want {
+			t.Errorf("expected count %d, got %d", want, count)
+		}
+	}
+
+	if count := mustIncrement(t, h.store, "ip:2.2.2.2"); count != 1 {
+		t.Errorf("expected keys to be counted independently, got %d", count)
+	}
+}
+
+func testIncrementWindowExpiry(t *testing.T, h harness) {
+	mustIncrement(t, h.store, "ip:1.1.1.1")
+	mustIncrement(t, h.store, "ip:1.1.1.1")
+
+	h.clock.Advance(999 * time.Millisecond)
+	if count := mustIncrement(t, h.store, "ip:1.1.1.1"); count != 3 {
+		t.Errorf("expected count 3 within the window, got %d", count)
+	}
+
+	h.clock.Advance(time.Millisecond)
+	if count := mustIncrement(t, h.store, "ip:1.1.1.1"); count != 1 {
+		t.Errorf("expected count to restart in the next window, got %d", count)
+	}
+}
+
+func testIncrementLongWindow(t *testing.T, h harness) {
+	ctx := context.Background()
+	h.clock.Advance(10 * time.Second)
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := h.store.Increment(ctx, "ip:1.1.1.1", 60)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != want {
+			t.Errorf("expected count %d, got %d", want, count)
+		}
+		h.clock.Advance(15 * time.Second)
+	}
+
+	h.clock.Advance(5 * time.Second)
+	count, err := h.store.Increment(ctx, "ip:1.1.1.1", 60)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected count to restart at the minute boundary, got %d", count)
+	}
+}
+
+func testIncrementParallel(t *testing.T, h harness) {
+	const workers, perWorker = 20, 25
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, err := h.store.Increment(context.Background(), "ip:1.1.1.1", 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if count := mustIncrement(t, h.store, "ip:1.1.1.1"); count != workers*perWorker+1 {
+		t.Errorf("expected count %d, got %d", workers*perWorker+1, count)
+	}
+}
+
+func testBlock(t *testing.T, h harness) {
+	ctx := context.Background()
+
+	blocked, err := h.store.IsBlocked(ctx, "ip:1.1.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocked {
+		t.Fatal("expected key not to be blocked initially")
+	}
+
+	if err := h.store.Block(ctx, "ip:1.1.1.1", 10*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h.clock.Advance(9 * time.Second)
+	blocked, err = h.store.IsBlocked(ctx, "ip:1.1.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !blocked {
+		t.Error("expected key to be blocked within the block duration")
+	}
+
+	blocked, err = h.store.IsBlocked(ctx, "ip:2.2.2.2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocked {
+		t.Error("expected other keys not to be blocked")
+	}
+
+	h.clock.Advance(time.Second)
+	blocked, err = h.store.IsBlocked(ctx, "ip:1.1.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocked {
+		t.Error("expected block to expire")
+	}
+}
+
+func testBlockTTL(t *testing.T, h harness) {
+	store := capability[limiter.BlockTTLStore](t, h.store)
+	ctx := context.Background()
+
+	if _, blocked, err := store.BlockTTL(ctx, "ip:1.1.1.1"); err != nil || blocked {
+		t.Fatalf("expected key not to be blocked, got blocked=%v err=%v", blocked, err)
+	}
+
+	if err := h.store.Block(ctx, "ip:1.1.1.1", 10*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h.clock.Advance(4 * time.Second)
+	ttl, blocked, err := store.BlockTTL(ctx, "ip:1.1.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !blocked || ttl != 6*time.Second {
+		t.Errorf("expected key blocked for 6s more, got blocked=%v ttl=%v", blocked, ttl)
+	}
+}
+
+func testCanceledContext(t *testing.T, h harness) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := h.store.Increment(ctx, "ip:1.1.1.1", 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Increment to fail with context.Canceled, got %v", err)
+	}
+	if _, err := h.store.IsBlocked(ctx, "ip:1.1.1.1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected IsBlocked to fail with context.Canceled, got %v", err)
+	}
+	if err := h.store.Block(ctx, "ip:1.1.1.1", time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Block to fail with context.Canceled, got %v", err)
+	}
+
+	if count := mustIncrement(t, h.store, "ip:1.1.1.1"); count != 1 {
+		t.Errorf("expected canceled increment not to be counted, got %d", count)
+	}
+	blocked, err := h.store.IsBlocked(context.Background(), "ip:1.1.1.1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocked {
+		t.Error("expected canceled block not to be applied")
+	}
+}
+
+func testCheckAndIncrement(t *testing.T, h harness) {
+	store := capability[limiter.AtomicStore](t, h.store)
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		result, err := store.CheckAndIncrement(ctx, "ip:1.1.1.1", 1, 2, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Blocked || result.Count != int64(i) {
+			t.Errorf("request %d: unexpected result %+v", i, result)
+		}
+	}
+
+	result, err := store.CheckAndIncrement(ctx, "ip:1.1.1.1", 1, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Blocked || result.Count != 3 || result.BlockTTL != time.Minute {
+		t.Errorf("expected the request over the limit to block the key, got %+v", result)
+	}
+
+	h.clock.Advance(20 * time.Second)
+	result, err = store.CheckAndIncrement(ctx, "ip:1.1.1.1", 1, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Blocked || result.Count != 0 || result.BlockTTL != 40*time.Second {
+		t.Errorf("expected blocked key with 40s left and no count, got %+v", result)
+	}
+}
+
+func testCheckAndIncrementLimits(t *testing.T, h harness) {
+	store := capability[limiter.MultiLimitStore](t, h.store)
+	ctx := context.Background()
+	checks := []limiter.LimitCheck{
+		{Key: "token:abc", WindowSec: 1, Limit: 2},
+		{Key: "token:abc:60s", WindowSec: 60, Limit: 3, BlockDuration: 10 * time.Minute},
+	}
+
+	check := func(want ...limiter.CheckResult) {
+		t.Helper()
+
+		results, err := store.CheckAndIncrementLimits(ctx, checks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := range want {
+			if results[i] != want[i] {
+				t.Errorf("limit %d: expected %+v, got %+v", i, want[i], results[i])
+			}
+		}
+	}
+
+	check(limiter.CheckResult{Count: 1}, limiter.CheckResult{Count: 1})
+	check(limiter.CheckResult{Count: 2}, limiter.CheckResult{Count: 2})
+	// Over the per-second limit: nothing is counted and nothing is blocked.
+	check(limiter.CheckResult{Count: 3}, limiter.CheckResult{Count: 3})
+
+	h.clock.Advance(time.Second)
+	check(limiter.CheckResult{Count: 1}, limiter.CheckResult{Count: 3})
+
+	h.clock.Advance(time.Second)
+	check(limiter.CheckResult{Count: 1}, limiter.CheckResult{Count: 4, Blocked: true, BlockTTL: 10 * time.Minute})
+
+	h.clock.Advance(time.Second)
+	check(limiter.CheckResult{}, limiter.CheckResult{Blocked: true, BlockTTL: 10*time.Minute - time.Second})
+}
+
+func testCheckAndIncrementParallel(t *testing.T, h harness) {
+	store := capability[limiter.AtomicStore](t, h.store)
+	const limit = 10
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.CheckAndIncrement(context.Background(), "ip:1.1.1.1", 1, limit, time.Minute)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if !result.Blocked {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != limit {
+		t.Errorf("expected exactly %d requests allowed, got %d", limit, got)
+	}
+}
+
+func testSlidingLog(t *testing.T, h harness) {
+	store := capability[limiter.SlidingLogStore](t, h.store)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := store.SlidingLog(ctx, "ip:1.1.1.1", 3, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h.clock.Advance(400 * time.Millisecond)
+	}
+
+	count, oldest, err := store.SlidingLog(ctx, "ip:1.1.1.1", 3, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected count 3 after the first entry expired, got %d", count)
+	}
+	if want := Epoch.Add(400 * time.Millisecond); !oldest.Equal(want) {
+		t.Errorf("expected oldest entry at %v, got %v", want, oldest)
+	}
+
+	count, _, err = store.SlidingLog(ctx, "ip:1.1.1.1", 3, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected request over the limit to report count 4, got %d", count)
+	}
+}
+
+func testSlidingCounter(t *testing.T, h harness) {
+	store := capability[limiter.SlidingCounterStore](t, h.store)
+	ctx := context.Background()
+	h.clock.Advance(500 * time.Millisecond)
+
+	for i := 0; i < 12; i++ {
+		if _, err := store.SlidingCounter(ctx, "ip:1.1.1.1", 10, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	h.clock.Advance(time.Second)
+	count, err := store.SlidingCounter(ctx, "ip:1.1.1.1", 10, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 6 {
+		t.Errorf("expected half of the previous window plus this request (6), got %d", count)
+	}
+
+	h.clock.Advance(2 * time.Second)
+	count, err = store.SlidingCounter(ctx, "ip:1.1.1.1", 10, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected old windows to expire, got %d", count)
+	}
+}
+
+func testTokenBucket(t *testing.T, h harness) {
+	store := capability[limiter.TokenBucketStore](t, h.store)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := store.TakeTokens(ctx, "ip:1.1.1.1", 3, 100*time.Millisecond, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("request %d: expected token to be available", i+1)
+		}
+	}
+
+	allowed, tokens, err := store.TakeTokens(ctx, "ip:1.1.1.1", 3, 100*time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed || tokens != 0 {
+		t.Errorf("expected empty bucket, got allowed=%v tokens=%v", allowed, tokens)
+	}
+
+	h.clock.Advance(250 * time.Millisecond)
+	allowed, tokens, err = store.TakeTokens(ctx, "ip:1.1.1.1", 3, 100*time.Millisecond, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allowed || tokens != 1.5 {
+		t.Errorf("expected refill of 2.5 tokens, got allowed=%v tokens=%v", allowed, tokens)
+	}
+
+	h.clock.Advance(time.Minute)
+	_, tokens, err = store.TakeTokens(ctx, "ip:1.1.1.1", 3, 100*time.Millisecond, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens != 1 {
+		t.Errorf("expected refill to stop at the capacity, got %v tokens left", tokens)
+	}
+}
+
+func testGCRA(t *testing.T, h harness) {
+	store := capability[limiter.GCRAStore](t, h.store)
+	ctx := context.Background()
+	interval := 100 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		allowed, _, _, err := store.GCRA(ctx, "ip:1.1.1.1", interval, 2*interval, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("request %d: expected request within the burst", i+1)
+		}
+	}
+
+	allowed, retryAfter, resetAfter, err := store.GCRA(ctx, "ip:1.1.1.1", interval, 2*interval, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed || retryAfter != interval || resetAfter != 2*interval {
+		t.Errorf("expected rejection with retry-after 100ms and reset 200ms, got allowed=%v retry=%v reset=%v", allowed, retryAfter, resetAfter)
+	}
+
+	h.clock.Advance(interval)
+	allowed, _, _, err = store.GCRA(ctx, "ip:1.1.1.1", interval, 2*interval, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allowed {
+		t.Error("expected request to be allowed after the retry-after")
+	}
+}
+
+func testConcurrency(t *testing.T, h harness) {
+	store := capability[limiter.ConcurrencyStore](t, h.store)
+	ctx := context.Background()
+
+	acquired, inFlight, err := store.Acquire(ctx, "ip:1.1.1.1", "a", 2, time.Minute)
+	if err != nil || !acquired || inFlight != 1 {
+		t.Fatalf("expected first lease, got acquired=%v inFlight=%d err=%v", acquired, inFlight, err)
+	}
+	acquired, inFlight, err = store.Acquire(ctx, "ip:1.1.1.1", "b", 2, 10*time.Second)
+	if err != nil || !acquired || inFlight != 2 {
+		t.Fatalf("expected second lease, got acquired=%v inFlight=%d err=%v", acquired, inFlight, err)
+	}
+	acquired, _, err = store.Acquire(ctx, "ip:1.1.1.1", "c", 2, time.Minute)
+	if err != nil || acquired {
+		t.Fatalf("expected third lease to be rejected, got acquired=%v err=%v", acquired, err)
+	}
+
+	if err := store.Release(ctx, "ip:1.1.1.1", "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	acquired, _, err = store.Acquire(ctx, "ip:1.1.1.1", "c", 2, time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("expected lease after release, got acquired=%v err=%v", acquired, err)
+	}
+
+	if err := store.Extend(ctx, "ip:1.1.1.1", "c", 5*time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h.clock.Advance(2 * time.Minute)
+
+	acquired, inFlight, err = store.Acquire(ctx, "ip:1.1.1.1", "d", 2, time.Minute)
+	if err != nil || !acquired || inFlight != 2 {
+		t.Errorf("expected expired lease b to be reclaimed and extended lease c kept, got acquired=%v inFlight=%d err=%v", acquired, inFlight, err)
+	}
+}
+
+func testConcurrencyParallel(t *testing.T, h harness) {
+	store := capability[limiter.ConcurrencyStore](t, h.store)
+	const limit = 5
+
+	var acquired atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := store.Acquire(context.Background(), "ip:1.1.1.1", fmt.Sprintf("lease-%d", i), limit, time.Minute)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if ok {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := acquired.Load(); got != limit {
+		t.Errorf("expected exactly %d leases, got %d", limit, got)
+	}
+}
@@ -0,0 +1,46 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+func (rl *RateLimiter) checkTokenBucket(ctx context.Context, r rule, now time.Time) (Decision, error) {
+	bucketStore, ok := rl.store.(TokenBucketStore)
+	if !ok {
+		return Decision{}, unsupported(r.algorithm)
+	}
+
+	if r.limit <= 0 {
+		return r.decision(), nil
+	}
+
+	interval := emissionInterval(r)
+	allowed, tokens, err := bucketStore.TakeTokens(ctx, r.key, r.burst, interval, r.cost)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := r.decision()
+	decision.Allowed = allowed
+	decision.Limit = r.burst
+	decision.Remaining = int(math.Floor(tokens))
+	decision.ResetAt = now.Add(tokensDuration(float64(r.burst)-tokens, interval))
+	if !allowed {
+		decision.RetryAfter = tokensDuration(float64(r.cost)-tokens, interval)
+	}
+	return decision, nil
+}
+
+// emissionInterval returns the time it takes to replenish one request, at least a microsecond.
+func emissionInterval(r rule) time.Duration {
+	return max(r.window/time.Duration(r.limit), time.Microsecond)
+}
+
+func tokensDuration(tokens float64, interval time.Duration) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens * float64(interval)))
+}
@@ -0,0 +1,117 @@
+package limiter
+
+import "context"
+
+// TokenProvider looks up the config of a token, or of a plan named by Identity.Plan.
+type TokenProvider interface {
+	TokenConfig(ctx context.Context, name string) (config TokenConfig, found bool, err error)
+}
+
+// StaticTokens serves a fixed set of token configs.
+type StaticTokens map[string]TokenConfig
+
+func (s StaticTokens) TokenConfig(_ context.Context, name string) (TokenConfig, bool, error) {
+	config, found := s[name]
+	return config, found, nil
+}
+
+// TokenProviders returns the first config found among its providers.
+type TokenProviders []TokenProvider
+
+func (p TokenProviders) TokenConfig(ctx context.Context, name string) (TokenConfig, bool, error) {
+	for _, provider := range p {
+		config, found, err := provider.TokenConfig(ctx, name)
+		if err != nil || found {
+			return config, found, err
+		}
+	}
+	return TokenConfig{}, false, nil
+}
+
+// lookupIdentity returns the config of the plan named by id, or else that of its token.
+func lookupIdentity(ctx context.Context, tokens, plans TokenProvider, id Identity) (TokenConfig, bool, error) {
+	if id.Plan == "" {
+		return tokens.TokenConfig(ctx, id.Token)
+	}
+	if plans == nil {
+		plans = tokens
+	}
+
+	config, found, err := plans.TokenConfig(ctx, id.Plan)
+	if err != nil || !found {
+		return TokenConfig{}, false, err
+	}
+	config.Plan = id.Plan
+	return config, true, nil
+}
+
+// ResolvePlans returns a provider serving the tokens of tokens with their plan applied.
+func ResolvePlans(tokens, plans TokenProvider) TokenProvider {
+	return planResolver{tokens: tokens, plans: plans}
+}
+
+type planResolver struct {
+	tokens TokenProvider
+	plans  TokenProvider
+}
+
+func (r planResolver) TokenConfig(ctx context.Context, name string) (TokenConfig, bool, error) {
+	config, found, err := r.tokens.TokenConfig(ctx, name)
+	if err != nil || !found || config.Plan == "" {
+		return config, found, err
+	}
+
+	plan, found, err := r.plans.TokenConfig(ctx, config.Plan)
+	if err != nil {
+		return TokenConfig{}, false, err
+	}
+	if !found {
+		if config.Limit > 0 {
+			return config, true, nil
+		}
+		return TokenConfig{}, false, nil
+	}
+	return ApplyPlan(plan, config), true, nil
+}
+
+// ApplyPlan returns the config of plan overridden by the settings of token.
+func ApplyPlan(plan, token TokenConfig) TokenConfig {
+	plan.Plan = token.Plan
+	if token.Limit > 0 {
+		plan.Limit = token.Limit
+	}
+	if token.BlockDuration > 0 {
+		plan.BlockDuration = token.BlockDuration
+	}
+	if token.Algorithm != "" {
+		plan.Algorithm = token.Algorithm
+	}
+	if token.Window > 0 {
+		plan.Window = token.Window
+	}
+	if token.Burst > 0 {
+		plan.Burst = token.Burst
+	}
+	if token.Cost > 0 {
+		plan.Cost = token.Cost
+	}
+	if token.MaxWait > 0 {
+		plan.MaxWait = token.MaxWait
+	}
+	if token.Concurrency > 0 {
+		plan.Concurrency = token.Concurrency
+	}
+	if len(token.Limits) > 0 {
+		plan.Limits = token.Limits
+	}
+	if token.CombineIP {
+		plan.CombineIP = true
+	}
+	if token.PairLimit > 0 {
+		plan.PairLimit = token.PairLimit
+	}
+	if token.Priority {
+		plan.Priority = true
+	}
+	return plan
+}
@@ -0,0 +1,96 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenProviders(t *testing.T) {
+	p := TokenProviders{
+		StaticTokens{"abc123": {Limit: 10}},
+		StaticTokens{"abc123": {Limit: 20}, "xyz789": {Limit: 30}},
+	}
+
+	if config, _ := lookupToken(t, p, "abc123"); config.Limit != 10 {
+		t.Errorf("expected the first provider to win, got limit %d", config.Limit)
+	}
+	if config, _ := lookupToken(t, p, "xyz789"); config.Limit != 30 {
+		t.Errorf("expected xyz789 from the second provider, got limit %d", config.Limit)
+	}
+	if _, found := lookupToken(t, p, "unknown"); found {
+		t.Error("expected unknown token not to be found")
+	}
+}
+
+func TestResolvePlans(t *testing.T) {
+	plans := StaticTokens{"pro": {Limit: 100, Window: time.Minute, BlockDuration: time.Hour, Algorithm: FixedWindow, Priority: true}}
+	tokens := StaticTokens{
+		"plain":    {Limit: 5},
+		"pro":      {Plan: "pro"},
+		"override": {Plan: "pro", Limit: 200, BlockDuration: time.Minute},
+		"missing":  {Plan: "gold"},
+		"fallback": {Plan: "gold", Limit: 7},
+	}
+	p := ResolvePlans(tokens, plans)
+
+	tests := []struct {
+		token string
+		found bool
+		want  TokenConfig
+	}{
+		{"plain", true, TokenConfig{Limit: 5}},
+		{"pro", true, TokenConfig{Limit: 100, Window: time.Minute, BlockDuration: time.Hour, Algorithm: FixedWindow, Priority: true, Plan: "pro"}},
+		{"override", true, TokenConfig{Limit: 200, Window: time.Minute, BlockDuration: time.Minute, Algorithm: FixedWindow, Priority: true, Plan: "pro"}},
+		{"missing", false, TokenConfig{}},
+		{"fallback", true, TokenConfig{Limit: 7, Plan: "gold"}},
+	}
+
+	for _, tt := range tests {
+		config, found := lookupToken(t, p, tt.token)
+		if found != tt.found {
+			t.Errorf("%s: expected found %v, got %v", tt.token, tt.found, found)
+			continue
+		}
+		if config.Limit != tt.want.Limit || config.Window != tt.want.Window || config.BlockDuration != tt.want.BlockDuration ||
+			config.Priority != tt.want.Priority || config.Plan != tt.want.Plan {
+			t.Errorf("%s: expected %+v, got %+v", tt.token, tt.want, config)
+		}
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	plan := TokenConfig{
+		Limit:         100,
+		BlockDuration: time.Hour,
+		Algorithm:     FixedWindow,
+		Window:        time.Minute,
+		Concurrency:   5,
+		Limits:        []Limit{{Limit: 10000, Window: 24 * time.Hour, BlockDuration: time.Hour}},
+		Priority:      true,
+	}
+
+	config := ApplyPlan(plan, TokenConfig{Plan: "pro"})
+	if config.Plan != "pro" || config.Limit != 100 || config.Concurrency != 5 || len(config.Limits) != 1 || !config.Priority {
+		t.Errorf("expected the plan config without overrides, got %+v", config)
+	}
+
+	config = ApplyPlan(plan, TokenConfig{
+		Plan:        "pro",
+		Limit:       200,
+		Concurrency: 10,
+		Limits:      []Limit{{Limit: 50000, Window: 24 * time.Hour}},
+		CombineIP:   true,
+	})
+	if config.Limit != 200 || config.Window != time.Minute || config.BlockDuration != time.Hour || config.Concurrency != 10 {
+		t.Errorf("expected the token's settings to override the plan's, got %+v", config)
+	}
+	if len(config.Limits) != 1 || config.Limits[0].Limit != 50000 {
+		t.Errorf("expected the token's extra limits to replace the plan's, got %+v", config.Limits)
+	}
+	if !config.CombineIP || !config.Priority {
+		t.Errorf("expected boolean options of both to be set, got %+v", config)
+	}
+	if plan.Limit != 100 || plan.Plan != "" {
+		t.Errorf("expected the plan to be left unchanged, got %+v", plan)
+	}
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPHeader selects the header trusted proxies forward the client address in.
+type IPHeader string
+
+const (
+	IPHeaderForwardedFor IPHeader = "x-forwarded-for"
+	IPHeaderRealIP       IPHeader = "x-real-ip"
+	IPHeaderForwarded    IPHeader = "forwarded"
+)
+
+func ParseIPHeader(s string) (IPHeader, error) {
+	switch header := IPHeader(strings.ToLower(s)); header {
+	case IPHeaderForwardedFor, IPHeaderRealIP, IPHeaderForwarded:
+		return header, nil
+	default:
+		return "", fmt.Errorf("unknown client ip header: %s", s)
+	}
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or addresses.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ClientIPResolver finds the client address behind at most hops trusted proxies.
+type ClientIPResolver struct {
+	header  IPHeader
+	trusted []netip.Prefix
+	hops    int
+}
+
+func NewClientIPResolver(header IPHeader, trusted []netip.Prefix, hops int) *ClientIPResolver {
+	if hops < 1 {
+		hops = 1
+	}
+
+	return &ClientIPResolver{
+		header:  header,
+		trusted: trusted,
+		hops:    hops,
+	}
+}
+
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	peer := remoteIP(r)
+
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !c.isTrusted(addr) {
+		return peer
+	}
+
+	chain := c.forwardedChain(r.Header)
+	for hop := 0; hop < c.hops && len(chain) > 0 && c.isTrusted(addr); hop++ {
+		next, ok := parseForwardedAddr(chain[len(chain)-1])
+		if !ok {
+			break
+		}
+		addr, chain = next, chain[:len(chain)-1]
+	}
+
+	return addr.String()
+}
+
+func (c *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedChain returns the forwarded addresses from the client to the closest proxy.
+func (c *ClientIPResolver) forwardedChain(h http.Header) []string {
+	switch c.header {
+	case IPHeaderRealIP:
+		if ip := strings.TrimSpace(h.Get("X-Real-IP")); ip != "" {
+			return []string{ip}
+		}
+		return nil
+	case IPHeaderForwarded:
+		return parseForwardedHeader(h.Values("Forwarded"))
+	default:
+		var chain []string
+		for _, value := range h.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(ip))
+			}
+		}
+		return chain
+	}
+}
+
+// parseForwardedHeader extracts the for= parameters of an RFC 7239 Forwarded header.
+func parseForwardedHeader(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(key), "for") {
+					node = strings.Trim(strings.TrimSpace(val), `"`)
+				}
+			}
+			chain = append(chain, node)
+		}
+	}
+	return chain
+}
+
+// parseForwardedAddr accepts an address with or without a port; "unknown" is rejected.
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr(), true
+	}
+
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+
+		ip = r.RemoteAddr
+	}
+	return ip
+}
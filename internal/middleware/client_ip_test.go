@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestResolver(t *testing.T, header IPHeader, trusted string, hops int) *ClientIPResolver {
+	t.Helper()
+
+	prefixes, err := ParseTrustedProxies(trusted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return NewClientIPResolver(header, prefixes, hops)
+}
+
+func TestClientIPResolver_ClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     IPHeader
+		hops       int
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer ignores forwarded for",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "203.0.113.7:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer ignores real ip",
+			header:     IPHeaderRealIP,
+			remoteAddr: "203.0.113.7:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer ignores forwarded",
+			header:     IPHeaderForwarded,
+			remoteAddr: "203.0.113.7:1234",
+			headers:    map[string][]string{"Forwarded": {"for=1.2.3.4"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted peer without header",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "10.0.0.2:1234",
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "trusted peer forwarded for",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "spoofed entries before the proxy entry are ignored",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 7.7.7.7", "198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "multiple trusted hops",
+			header:     IPHeaderForwardedFor,
+			hops:       2,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.9, 10.0.0.3"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "hop count bounds trusted proxies",
+			header:     IPHeaderForwardedFor,
+			hops:       1,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.9, 10.0.0.3"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "untrusted hop stops the walk",
+			header:     IPHeaderForwardedFor,
+			hops:       3,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "invalid entry keeps the last proxy",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"not-an-ip"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "trusted peer real ip",
+			header:     IPHeaderRealIP,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "real ip selected ignores forwarded for",
+			header:     IPHeaderRealIP,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "trusted peer forwarded",
+			header:     IPHeaderForwarded,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"Forwarded": {"for=6.6.6.6, for=198.51.100.9;proto=https;by=10.0.0.2"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "forwarded quoted ipv6 with port",
+			header:     IPHeaderForwarded,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "forwarded unknown node keeps the last proxy",
+			header:     IPHeaderForwarded,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"Forwarded": {"for=unknown"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "forwarded selected ignores forwarded for",
+			header:     IPHeaderForwarded,
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "ipv4 mapped trusted peer",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "[::ffff:10.0.0.2]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.9"}},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "trusted ipv6 peer",
+			header:     IPHeaderForwardedFor,
+			remoteAddr: "[fd00::1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			want:       "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newTestResolver(t, tt.header, "10.0.0.0/8, fd00::/8", tt.hops)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Errorf("expected client ip %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(prefixes) != 3 {
+		t.Fatalf("expected 3 prefixes, got %d", len(prefixes))
+	}
+	if prefixes[1].String() != "192.168.1.10/32" {
+		t.Errorf("expected single address as /32, got %s", prefixes[1])
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies("proxy.internal"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
+
+func TestParseIPHeader(t *testing.T) {
+	header, err := ParseIPHeader("X-Forwarded-For")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header != IPHeaderForwardedFor {
+		t.Errorf("expected %s, got %s", IPHeaderForwardedFor, header)
+	}
+
+	if _, err := ParseIPHeader("True-Client-IP"); err == nil {
+		t.Error("expected error for unknown header")
+	}
+}
+
+func TestRateLimiter_UsesClientIPResolver(t *testing.T) {
+	stub := &stubLimiter{}
+	resolver := newTestResolver(t, IPHeaderForwardedFor, "10.0.0.0/8", 1)
+
+	handler := RateLimiter(stub, WithClientIPResolver(resolver))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if stub.id.IP != "198.51.100.9" {
+		t.Errorf("expected limiter to see client ip 198.51.100.9, got %s", stub.id.IP)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if stub.id.IP != "203.0.113.7" {
+		t.Errorf("expected spoofed header from untrusted peer to be ignored, got %s", stub.id.IP)
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context, id limiter.Identity) (limiter.Decision, func(), error)
+}
+
+// Concurrency rejects requests while the client has the maximum number of requests in flight.
+func Concurrency(cl ConcurrencyLimiter, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, req := o.key, r
+			if o.routes != nil {
+				if route, matched := o.routes.Match(r); route != nil {
+					key, req = route.Key, matched
+				}
+			}
+
+			id, err := o.identify(req, key)
+			if err != nil {
+				http.Error(w, invalidTokenMessage, http.StatusUnauthorized)
+				return
+			}
+
+			decision, release, err := cl.Acquire(r.Context(), id)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			if !decision.Allowed {
+				http.Error(w, "you have reached the maximum number of concurrent requests allowed", http.StatusTooManyRequests)
+				return
+			}
+			defer release()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
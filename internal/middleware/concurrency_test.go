@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+type stubConcurrencyLimiter struct {
+	allowed  bool
+	err      error
+	released int
+}
+
+func (s *stubConcurrencyLimiter) Acquire(ctx context.Context, id limiter.Identity) (limiter.Decision, func(), error) {
+	if s.err != nil {
+		return limiter.Decision{}, nil, s.err
+	}
+	return limiter.Decision{Allowed: s.allowed}, func() { s.released++ }, nil
+}
+
+func TestConcurrency_ReleasesAfterHandler(t *testing.T) {
+	cl := &stubConcurrencyLimiter{allowed: true}
+	releasedDuringHandler := -1
+
+	handler := Concurrency(cl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		releasedDuringHandler = cl.released
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	if releasedDuringHandler != 0 {
+		t.Error("expected slot to be held while the handler runs")
+	}
+	if cl.released != 1 {
+		t.Errorf("expected slot to be released once, got %d", cl.released)
+	}
+}
+
+func TestConcurrency_ReleasesWhenHandlerPanics(t *testing.T) {
+	cl := &stubConcurrencyLimiter{allowed: true}
+
+	handler := Concurrency(cl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	func() {
+		defer func() { recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if cl.released != 1 {
+		t.Errorf("expected slot to be released after a panic, got %d", cl.released)
+	}
+}
+
+func TestConcurrency_Rejected(t *testing.T) {
+	handlerCalled := false
+	cl := &stubConcurrencyLimiter{allowed: false}
+
+	handler := Concurrency(cl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", rec.Code)
+	}
+	if handlerCalled {
+		t.Error("expected handler not to be called when rejected")
+	}
+	if cl.released != 0 {
+		t.Error("expected nothing to release for a rejected request")
+	}
+}
+
+func TestConcurrency_Error(t *testing.T) {
+	cl := &stubConcurrencyLimiter{err: errors.New("store error")}
+
+	handler := Concurrency(cl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", rec.Code)
+	}
+}
+
+func TestConcurrency_PlanLimit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	mr.HSet("ratelimit:token:xyz789", "plan", "pro")
+
+	plans := limiter.StaticTokens{"pro": {Limit: 100, Concurrency: 1}}
+	tokens := limiter.TokenProviders{
+		limiter.ResolvePlans(limiter.StaticTokens{"abc123": {Plan: "pro"}}, plans),
+		limiter.ResolvePlans(limiter.NewRedisTokenProvider(client), plans),
+	}
+	store := limiter.NewMemoryStore(0, time.Hour)
+	t.Cleanup(store.Close)
+
+	jwtKey, err := NewJWTKey(JWTConfig{Secret: testSecret, LimitClaim: "plan"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	planClaim := signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"sub": "user-1", "plan": "pro"})
+
+	tests := []struct {
+		name string
+		key  KeyExtractor
+		set  func(r *http.Request)
+	}{
+		{"configured token", DefaultKeyExtractor, func(r *http.Request) { r.Header.Set("API_KEY", "abc123") }},
+		{"redis token", DefaultKeyExtractor, func(r *http.Request) { r.Header.Set("API_KEY", "xyz789") }},
+		{"jwt plan claim", jwtKey, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+planClaim) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := limiter.NewConcurrencyLimiter(store, 10, nil, time.Minute,
+				limiter.WithConcurrencyTokenProvider(tokens),
+				limiter.WithConcurrencyPlanProvider(plans),
+			)
+
+			// The first request sends a second one from another address while
+			// it is in flight, which the plan's limit of 1 rejects.
+			innerStatus := 0
+			var handler http.Handler
+			handler = Concurrency(cl, WithKeyExtractor(tt.key))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.RemoteAddr == "192.0.2.1:1234" {
+					req := httptest.NewRequest(http.MethodGet, "/", nil)
+					req.RemoteAddr = "192.0.2.2:1234"
+					tt.set(req)
+					rec := httptest.NewRecorder()
+					handler.ServeHTTP(rec, req)
+					innerStatus = rec.Code
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			tt.set(req)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected the first request to be served, got %d", rec.Code)
+			}
+			if innerStatus != http.StatusTooManyRequests {
+				t.Errorf("expected the plan's concurrency limit to reject the second request, got %d", innerStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+// HeaderStyle selects which rate limit headers are written on responses.
+type HeaderStyle string
+
+const (
+	HeaderStyleNone   HeaderStyle = "none"
+	HeaderStyleLegacy HeaderStyle = "legacy"
+	HeaderStyleDraft  HeaderStyle = "draft"
+	HeaderStyleBoth   HeaderStyle = "both"
+)
+
+func ParseHeaderStyle(s string) (HeaderStyle, error) {
+	switch style := HeaderStyle(s); style {
+	case HeaderStyleNone, HeaderStyleLegacy, HeaderStyleDraft, HeaderStyleBoth:
+		return style, nil
+	default:
+		return "", fmt.Errorf("unknown header style: %s", s)
+	}
+}
+
+func writeHeaders(h http.Header, style HeaderStyle, decision limiter.Decision, now time.Time) {
+	if style == HeaderStyleNone {
+		return
+	}
+
+	resetIn := ceilSeconds(decision.ResetAt.Sub(now))
+
+	if style == HeaderStyleLegacy || style == HeaderStyleBoth {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(decision.ResetAt.Unix(), 10))
+	}
+
+	if style == HeaderStyleDraft || style == HeaderStyleBoth {
+		h.Set("RateLimit-Policy", fmt.Sprintf("%q;q=%d;w=%d", decision.Rule, decision.Limit, ceilSeconds(decision.Window)))
+		h.Set("RateLimit", fmt.Sprintf("%q;r=%d;t=%d", decision.Rule, decision.Remaining, resetIn))
+	}
+
+	if decision.Plan != "" {
+		h.Set("X-RateLimit-Plan", decision.Plan)
+	}
+
+	if !decision.Allowed && decision.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
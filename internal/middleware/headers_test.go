@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+type stubLimiter struct {
+	decision limiter.Decision
+	id       limiter.Identity
+}
+
+func (s *stubLimiter) CheckIdentity(ctx context.Context, id limiter.Identity) (limiter.Decision, error) {
+	s.id = id
+	return s.decision, nil
+}
+
+func serveWithDecision(decision limiter.Decision, opts ...Option) *httptest.ResponseRecorder {
+	handler := RateLimiter(&stubLimiter{decision: decision}, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiter_Headers_LegacyOnAllowed(t *testing.T) {
+	resetAt := time.Now().Add(time.Second).Truncate(time.Second)
+	rec := serveWithDecision(limiter.Decision{
+		Allowed:   true,
+		Limit:     10,
+		Remaining: 7,
+		Window:    time.Second,
+		ResetAt:   resetAt,
+		Rule:      limiter.RuleIP,
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "10" {
+		t.Errorf("expected X-RateLimit-Limit 10, got %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "7" {
+		t.Errorf("expected X-RateLimit-Remaining 7, got %q", got)
+	}
+	if got, want := rec.Header().Get("X-RateLimit-Reset"), resetAt.Unix(); got != fmt.Sprint(want) {
+		t.Errorf("expected X-RateLimit-Reset %d, got %q", want, got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "" {
+		t.Errorf("expected no Retry-After on allowed response, got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit"); got != "" {
+		t.Errorf("expected no draft headers in legacy style, got %q", got)
+	}
+}
+
+func TestRateLimiter_Headers_RetryAfterOnBlocked(t *testing.T) {
+	rec := serveWithDecision(limiter.Decision{
+		Allowed:    false,
+		Limit:      10,
+		Window:     time.Second,
+		ResetAt:    time.Now().Add(90 * time.Second),
+		RetryAfter: 89500 * time.Millisecond,
+		Rule:       limiter.RuleIP,
+	})
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "90" {
+		t.Errorf("expected Retry-After 90, got %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("expected X-RateLimit-Remaining 0, got %q", got)
+	}
+}
+
+func TestRateLimiter_Headers_Draft(t *testing.T) {
+	rec := serveWithDecision(limiter.Decision{
+		Allowed:   true,
+		Limit:     100,
+		Remaining: 42,
+		Window:    time.Second,
+		ResetAt:   time.Now().Add(500 * time.Millisecond),
+		Rule:      limiter.RuleToken,
+	}, WithHeaderStyle(HeaderStyleDraft))
+
+	if got := rec.Header().Get("RateLimit-Policy"); got != `"token";q=100;w=1` {
+		t.Errorf("unexpected RateLimit-Policy %q", got)
+	}
+	if got := rec.Header().Get("RateLimit"); got != `"token";r=42;t=1` {
+		t.Errorf("unexpected RateLimit %q", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "" {
+		t.Errorf("expected no legacy headers in draft style, got %q", got)
+	}
+}
+
+func TestRateLimiter_Headers_Both(t *testing.T) {
+	rec := serveWithDecision(limiter.Decision{
+		Allowed:   true,
+		Limit:     100,
+		Remaining: 42,
+		Window:    time.Second,
+		ResetAt:   time.Now().Add(time.Second),
+		Rule:      limiter.RuleToken,
+	}, WithHeaderStyle(HeaderStyleBoth))
+
+	if rec.Header().Get("X-RateLimit-Limit") == "" {
+		t.Error("expected legacy headers")
+	}
+	if rec.Header().Get("RateLimit") == "" {
+		t.Error("expected draft headers")
+	}
+}
+
+func TestRateLimiter_Headers_None(t *testing.T) {
+	rec := serveWithDecision(limiter.Decision{
+		Allowed:    false,
+		Limit:      10,
+		ResetAt:    time.Now().Add(time.Minute),
+		RetryAfter: time.Minute,
+	}, WithHeaderStyle(HeaderStyleNone))
+
+	for _, name := range []string{"X-RateLimit-Limit", "RateLimit", "RateLimit-Policy", "Retry-After"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("expected no %s header, got %q", name, got)
+		}
+	}
+}
+
+func TestRateLimiter_Headers_Plan(t *testing.T) {
+	decision := limiter.Decision{Allowed: true, Limit: 100, Remaining: 99, Window: time.Second, Rule: limiter.RuleToken, Plan: "pro"}
+
+	for _, style := range []HeaderStyle{HeaderStyleLegacy, HeaderStyleDraft, HeaderStyleBoth} {
+		if got := serveWithDecision(decision, WithHeaderStyle(style)).Header().Get("X-RateLimit-Plan"); got != "pro" {
+			t.Errorf("%s: expected X-RateLimit-Plan pro, got %q", style, got)
+		}
+	}
+	if got := serveWithDecision(decision, WithHeaderStyle(HeaderStyleNone)).Header().Get("X-RateLimit-Plan"); got != "" {
+		t.Errorf("expected no X-RateLimit-Plan with style none, got %q", got)
+	}
+
+	decision.Plan = ""
+	if got := serveWithDecision(decision).Header().Get("X-RateLimit-Plan"); got != "" {
+		t.Errorf("expected no X-RateLimit-Plan without a plan, got %q", got)
+	}
+}
+
+func TestParseHeaderStyle(t *testing.T) {
+	for _, s := range []string{"none", "legacy", "draft", "both"} {
+		if _, err := ParseHeaderStyle(s); err != nil {
+			t.Errorf("unexpected error for %q: %v", s, err)
+		}
+	}
+
+	if _, err := ParseHeaderStyle("invalid"); err == nil {
+		t.Error("expected error for invalid header style")
+	}
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type JWTConfig struct {
+	// Secret verifies HS256 tokens.
+	Secret []byte
+	// JWKSFile is a local JSON Web Key Set verifying RS256 and ES256 tokens.
+	JWKSFile string
+	// KeyClaim is the claim used as the limit key; it defaults to "sub".
+	KeyClaim string
+	// LimitClaim optionally names a claim holding a numeric limit or a plan name.
+	LimitClaim string
+	// Reject makes invalid or expired tokens fail the request instead of using the IP limit.
+	Reject bool
+}
+
+// JWTKey limits requests by a claim of the bearer JWT, keyed as "jwt:<claim>".
+type JWTKey struct {
+	parser     *jwt.Parser
+	secret     []byte
+	keys       map[string]any
+	keyClaim   string
+	limitClaim string
+	reject     bool
+}
+
+func NewJWTKey(config JWTConfig) (*JWTKey, error) {
+	k := &JWTKey{
+		secret:     config.Secret,
+		keyClaim:   config.KeyClaim,
+		limitClaim: config.LimitClaim,
+		reject:     config.Reject,
+	}
+	if k.keyClaim == "" {
+		k.keyClaim = "sub"
+	}
+
+	var methods []string
+	if len(config.Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("jwt requires a secret or a JWKS file")
+	}
+
+	k.parser = jwt.NewParser(jwt.WithValidMethods(methods), jwt.WithJSONNumber())
+	return k, nil
+}
+
+func (k *JWTKey) ExtractKey(r *http.Request) string {
+	id, _ := k.ExtractIdentity(r)
+	return id.Token
+}
+
+func (k *JWTKey) ExtractIdentity(r *http.Request) (limiter.Identity, error) {
+	raw := BearerKey().ExtractKey(r)
+	if raw == "" {
+		return limiter.Identity{}, nil
+	}
+
+	id, err := k.identity(raw)
+	if err != nil {
+		if k.reject {
+			return limiter.Identity{}, err
+		}
+		return limiter.Identity{}, nil
+	}
+	return id, nil
+}
+
+func (k *JWTKey) identity(raw string) (limiter.Identity, error) {
+	claims := jwt.MapClaims{}
+	if _, err := k.parser.ParseWithClaims(raw, claims, k.verificationKey); err != nil {
+		return limiter.Identity{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	key, ok := claimString(claims[k.keyClaim])
+	if !ok || key == "" {
+		return limiter.Identity{}, fmt.Errorf("%w: missing claim %s", ErrInvalidToken, k.keyClaim)
+	}
+	id := limiter.Identity{Token: "jwt:" + key}
+
+	if k.limitClaim != "" {
+		if value, ok := claimString(claims[k.limitClaim]); ok && value != "" {
+			if limit, err := strconv.Atoi(value); err == nil {
+				id.Limit = limit
+			} else {
+				id.Plan = value
+			}
+		}
+	}
+	return id, nil
+}
+
+func (k *JWTKey) verificationKey(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return k.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Without a kid, a set holding a single key of the token's type is unambiguous.
+	if kid == "" {
+		var match any
+		for _, key := range k.keys {
+			if keyMatches(token.Method, key) {
+				if match != nil {
+					return nil, errors.New("token has no kid and the JWKS has several keys")
+				}
+				match = key
+			}
+		}
+		if match != nil {
+			return match, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func keyMatches(method jwt.SigningMethod, key any) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodRSA)
+		return ok
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	}
+	return false
+}
+
+func claimString(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the RSA and P-256 public keys of a JSON Web Key Set by key id.
+func loadJWKS(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS %s: %w", path, err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for i, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d in JWKS %s: %w", i, path, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
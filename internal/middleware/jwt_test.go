@@ -0,0 +1,262 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = []byte("test-secret")
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func writeJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecPoint, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatalf("failed to encode EC key: %v", err)
+	}
+
+	set := map[string]any{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:])},
+		},
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to marshal JWKS: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+func bearerRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTKey_HS256(t *testing.T) {
+	k, err := NewJWTKey(JWTConfig{Secret: testSecret, KeyClaim: "tenant_id", LimitClaim: "plan"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		want  limiter.Identity
+	}{
+		{
+			name:  "claim as key",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"tenant_id": "acme", "exp": future}),
+			want:  limiter.Identity{Token: "jwt:acme"},
+		},
+		{
+			name:  "numeric claim as key",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"tenant_id": 42}),
+			want:  limiter.Identity{Token: "jwt:42"},
+		},
+		{
+			name:  "plan claim",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"tenant_id": "acme", "plan": "pro"}),
+			want:  limiter.Identity{Token: "jwt:acme", Plan: "pro"},
+		},
+		{
+			name:  "limit claim",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"tenant_id": "acme", "plan": 500}),
+			want:  limiter.Identity{Token: "jwt:acme", Limit: 500},
+		},
+		{
+			name:  "no token",
+			token: "",
+			want:  limiter.Identity{},
+		},
+		{
+			name:  "expired token falls back to ip",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"tenant_id": "acme", "exp": past}),
+			want:  limiter.Identity{},
+		},
+		{
+			name:  "wrong secret falls back to ip",
+			token: signToken(t, jwt.SigningMethodHS256, []byte("other"), "", jwt.MapClaims{"tenant_id": "acme"}),
+			want:  limiter.Identity{},
+		},
+		{
+			name:  "missing claim falls back to ip",
+			token: signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"sub": "user-1"}),
+			want:  limiter.Identity{},
+		},
+		{
+			name:  "unsigned token falls back to ip",
+			token: signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", jwt.MapClaims{"tenant_id": "acme"}),
+			want:  limiter.Identity{},
+		},
+		{
+			name:  "malformed token falls back to ip",
+			token: "not-a-jwt",
+			want:  limiter.Identity{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := k.ExtractIdentity(bearerRequest(tt.token))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != tt.want {
+				t.Errorf("expected identity %+v, got %+v", tt.want, id)
+			}
+		})
+	}
+}
+
+func TestJWTKey_RejectsInvalidTokens(t *testing.T) {
+	k, err := NewJWTKey(JWTConfig{Secret: testSecret, Reject: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expired := signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()})
+	if _, err := k.ExtractIdentity(bearerRequest(expired)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for expired token, got %v", err)
+	}
+
+	id, err := k.ExtractIdentity(bearerRequest(""))
+	if err != nil || id != (limiter.Identity{}) {
+		t.Errorf("expected requests without a token to be limited by ip, got %+v, %v", id, err)
+	}
+}
+
+func TestJWTKey_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+
+	k, err := NewJWTKey(JWTConfig{JWKSFile: writeJWKS(t, rsaKey, ecKey), Reject: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{"sub": "user-1"}
+	valid := map[string]string{
+		"RS256 with kid":    signToken(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", claims),
+		"ES256 with kid":    signToken(t, jwt.SigningMethodES256, ecKey, "ec-1", claims),
+		"RS256 without kid": signToken(t, jwt.SigningMethodRS256, rsaKey, "", claims),
+	}
+	for name, token := range valid {
+		id, err := k.ExtractIdentity(bearerRequest(token))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if id.Token != "jwt:user-1" {
+			t.Errorf("%s: expected key jwt:user-1, got %q", name, id.Token)
+		}
+	}
+
+	invalid := map[string]string{
+		"unknown signer":           signToken(t, jwt.SigningMethodES256, otherKey, "ec-1", claims),
+		"unknown kid":              signToken(t, jwt.SigningMethodES256, ecKey, "ec-2", claims),
+		"HS256 without secret":     signToken(t, jwt.SigningMethodHS256, testSecret, "", claims),
+		"RS384 not allowed":        signToken(t, jwt.SigningMethodRS384, rsaKey, "rsa-1", claims),
+		"RS256 signed with EC kid": signToken(t, jwt.SigningMethodRS256, rsaKey, "ec-1", claims),
+	}
+	for name, token := range invalid {
+		if _, err := k.ExtractIdentity(bearerRequest(token)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestNewJWTKey_Errors(t *testing.T) {
+	if _, err := NewJWTKey(JWTConfig{}); err == nil {
+		t.Error("expected error without secret or JWKS file")
+	}
+	if _, err := NewJWTKey(JWTConfig{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("expected error for missing JWKS file")
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","crv":"P-384","x":"","y":""}]}`), 0o600)
+	if _, err := NewJWTKey(JWTConfig{JWKSFile: path}); err == nil {
+		t.Error("expected error for unsupported curve")
+	}
+}
+
+func TestRateLimiter_JWTKey(t *testing.T) {
+	k, err := NewJWTKey(JWTConfig{Secret: testSecret, LimitClaim: "plan", Reject: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rl := &stubLimiter{decision: limiter.Decision{Allowed: true}}
+	handler := RateLimiter(rl, WithKeyExtractor(k))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := bearerRequest(signToken(t, jwt.SigningMethodHS256, testSecret, "", jwt.MapClaims{"sub": "user-1", "plan": "pro"}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	if want := (limiter.Identity{IP: "192.0.2.1", Token: "jwt:user-1", Plan: "pro"}); rl.id != want {
+		t.Errorf("expected identity %+v, got %+v", want, rl.id)
+	}
+
+	req = bearerRequest(signToken(t, jwt.SigningMethodHS256, []byte("forged"), "", jwt.MapClaims{"sub": "user-1"}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a forged token, got %d", rec.Code)
+	}
+}
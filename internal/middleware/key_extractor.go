@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+// KeyExtractor returns the key a request is limited by; an empty key limits by IP only.
+type KeyExtractor interface {
+	ExtractKey(r *http.Request) string
+}
+
+// IdentityExtractor is implemented by extractors that may reject a request's credentials.
+type IdentityExtractor interface {
+	ExtractIdentity(r *http.Request) (limiter.Identity, error)
+}
+
+type KeyExtractorFunc func(r *http.Request) string
+
+func (f KeyExtractorFunc) ExtractKey(r *http.Request) string {
+	return f(r)
+}
+
+// DefaultKeyExtractor reads the API_KEY header.
+var DefaultKeyExtractor = HeaderKey("API_KEY")
+
+func HeaderKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return r.Header.Get(name)
+	})
+}
+
+// BearerKey reads the token of an "Authorization: Bearer <token>" header.
+func BearerKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	})
+}
+
+func CookieKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	})
+}
+
+func QueryKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	})
+}
+
+// PathKey reads a wildcard of the http.ServeMux pattern that matched the request.
+func PathKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		return r.PathValue(name)
+	})
+}
+
+// CompositeKey joins the keys of every extractor with "+", or returns "" if any is missing.
+func CompositeKey(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		parts := make([]string, 0, len(extractors))
+		for _, e := range extractors {
+			key := e.ExtractKey(r)
+			if key == "" {
+				return ""
+			}
+			parts = append(parts, key)
+		}
+		return strings.Join(parts, "+")
+	})
+}
+
+// FirstKey returns the first non-empty key.
+func FirstKey(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) string {
+		for _, e := range extractors {
+			if key := e.ExtractKey(r); key != "" {
+				return key
+			}
+		}
+		return ""
+	})
+}
+
+// ParseKeyExtractor builds an extractor from a spec such as "bearer|header:API_KEY".
+func ParseKeyExtractor(spec string) (KeyExtractor, error) {
+	var alternatives []KeyExtractor
+	for _, alternative := range strings.Split(spec, "|") {
+		var parts []KeyExtractor
+		for _, part := range strings.Split(alternative, "+") {
+			e, err := parseKeySource(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, e)
+		}
+
+		if len(parts) == 1 {
+			alternatives = append(alternatives, parts[0])
+		} else {
+			alternatives = append(alternatives, CompositeKey(parts...))
+		}
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return FirstKey(alternatives...), nil
+}
+
+func parseKeySource(s string) (KeyExtractor, error) {
+	source, name, _ := strings.Cut(s, ":")
+	source = strings.ToLower(strings.TrimSpace(source))
+	name = strings.TrimSpace(name)
+
+	if source == "bearer" {
+		return BearerKey(), nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("invalid key source %q (expected source:name)", s)
+	}
+
+	switch source {
+	case "header":
+		return HeaderKey(name), nil
+	case "cookie":
+		return CookieKey(name), nil
+	case "query":
+		return QueryKey(name), nil
+	case "path":
+		return PathKey(name), nil
+	default:
+		return nil, fmt.Errorf("unknown key source %q", source)
+	}
+}
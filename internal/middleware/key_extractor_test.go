@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor KeyExtractor
+		setup     func(r *http.Request)
+		want      string
+	}{
+		{
+			name:      "default reads API_KEY",
+			extractor: DefaultKeyExtractor,
+			setup:     func(r *http.Request) { r.Header.Set("API_KEY", "abc123") },
+			want:      "abc123",
+		},
+		{
+			name:      "header",
+			extractor: HeaderKey("X-Tenant"),
+			setup:     func(r *http.Request) { r.Header.Set("X-Tenant", "acme") },
+			want:      "acme",
+		},
+		{
+			name:      "bearer",
+			extractor: BearerKey(),
+			setup:     func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc123") },
+			want:      "abc123",
+		},
+		{
+			name:      "bearer scheme is case insensitive",
+			extractor: BearerKey(),
+			setup:     func(r *http.Request) { r.Header.Set("Authorization", "bearer abc123") },
+			want:      "abc123",
+		},
+		{
+			name:      "bearer ignores other schemes",
+			extractor: BearerKey(),
+			setup:     func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") },
+			want:      "",
+		},
+		{
+			name:      "cookie",
+			extractor: CookieKey("session"),
+			setup:     func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: "s1"}) },
+			want:      "s1",
+		},
+		{
+			name:      "missing cookie",
+			extractor: CookieKey("session"),
+			setup:     func(r *http.Request) {},
+			want:      "",
+		},
+		{
+			name:      "query",
+			extractor: QueryKey("api_key"),
+			setup:     func(r *http.Request) { r.URL.RawQuery = "api_key=abc123&page=2" },
+			want:      "abc123",
+		},
+		{
+			name:      "path",
+			extractor: PathKey("tenant"),
+			setup:     func(r *http.Request) { r.SetPathValue("tenant", "acme") },
+			want:      "acme",
+		},
+		{
+			name:      "composite",
+			extractor: CompositeKey(HeaderKey("X-Tenant"), CookieKey("session")),
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Tenant", "acme")
+				r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+			},
+			want: "acme+s1",
+		},
+		{
+			name:      "composite with missing part",
+			extractor: CompositeKey(HeaderKey("X-Tenant"), CookieKey("session")),
+			setup:     func(r *http.Request) { r.Header.Set("X-Tenant", "acme") },
+			want:      "",
+		},
+		{
+			name:      "first falls back",
+			extractor: FirstKey(BearerKey(), HeaderKey("API_KEY")),
+			setup:     func(r *http.Request) { r.Header.Set("API_KEY", "abc123") },
+			want:      "abc123",
+		},
+		{
+			name:      "first prefers earlier extractors",
+			extractor: FirstKey(BearerKey(), HeaderKey("API_KEY")),
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer xyz789")
+				r.Header.Set("API_KEY", "abc123")
+			},
+			want: "xyz789",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(req)
+
+			if got := tt.extractor.ExtractKey(req); got != tt.want {
+				t.Errorf("expected key %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseKeyExtractor(t *testing.T) {
+	extractor, err := ParseKeyExtractor("bearer | header:X-Tenant+query:user")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?user=42", nil)
+	req.Header.Set("X-Tenant", "acme")
+	if got := extractor.ExtractKey(req); got != "acme+42" {
+		t.Errorf("expected composite key acme+42, got %q", got)
+	}
+
+	req.Header.Set("Authorization", "Bearer abc123")
+	if got := extractor.ExtractKey(req); got != "abc123" {
+		t.Errorf("expected bearer token to take precedence, got %q", got)
+	}
+
+	for _, spec := range []string{"", "header", "cookie:", "jwt:sub", "header:API_KEY|"} {
+		if _, err := ParseKeyExtractor(spec); err == nil {
+			t.Errorf("expected error for spec %q", spec)
+		}
+	}
+}
+
+func TestRateLimiter_KeyExtractorPerRoute(t *testing.T) {
+	tenants := &stubLimiter{}
+	users := &stubLimiter{}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /tenants/{tenant}/orders", RateLimiter(tenants, WithKeyExtractor(PathKey("tenant")))(ok))
+	mux.Handle("GET /users", RateLimiter(users, WithKeyExtractor(BearerKey()))(ok))
+
+	req := httptest.NewRequest(http.MethodGet, "/tenants/acme/orders", nil)
+	req.Header.Set("API_KEY", "abc123")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if tenants.id.Token != "acme" {
+		t.Errorf("expected tenant route to be limited by path value, got %q", tenants.id.Token)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer xyz789")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	if users.id.Token != "xyz789" {
+		t.Errorf("expected users route to be limited by bearer token, got %q", users.id.Token)
+	}
+}
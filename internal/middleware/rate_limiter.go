@@ -2,33 +2,128 @@ package middleware
 
 import (
 	"context"
-	"net"
+	"log"
 	"net/http"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+const (
+	limitExceededMessage = "you have reached the maximum number of requests or actions allowed within a certain time frame"
+	invalidTokenMessage  = "invalid or expired token"
+	globalLimitMessage   = "the service is receiving too many requests, please try again later"
 )
 
 type Limiter interface {
-	Allow(ctx context.Context, ip string, token string) (bool, error)
+	CheckIdentity(ctx context.Context, id limiter.Identity) (limiter.Decision, error)
+}
+
+// Refunder is implemented by limiters that can refund an allowed request that was not served.
+type Refunder interface {
+	Refund(ctx context.Context, id limiter.Identity) error
+}
+
+type Option func(*options)
+
+type options struct {
+	headerStyle  HeaderStyle
+	clientIP     *ClientIPResolver
+	key          KeyExtractor
+	routes       *RouteTable
+	globalStatus int
+	tokenHasher  TokenHasher
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		headerStyle:  HeaderStyleLegacy,
+		key:          DefaultKeyExtractor,
+		globalStatus: http.StatusServiceUnavailable,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func WithHeaderStyle(style HeaderStyle) Option {
+	return func(o *options) {
+		o.headerStyle = style
+	}
+}
+
+// WithClientIPResolver resolves the client address through trusted proxies.
+func WithClientIPResolver(resolver *ClientIPResolver) Option {
+	return func(o *options) {
+		o.clientIP = resolver
+	}
+}
+
+// WithKeyExtractor replaces the API_KEY header as the source of the client key.
+func WithKeyExtractor(extractor KeyExtractor) Option {
+	return func(o *options) {
+		o.key = extractor
+	}
+}
+
+// WithRoutes applies the limiter and key of the matching route rule; Concurrency applies only the key.
+func WithRoutes(routes *RouteTable) Option {
+	return func(o *options) {
+		o.routes = routes
+	}
+}
+
+// WithGlobalStatus sets the status of requests rejected by the global limit.
+func WithGlobalStatus(status int) Option {
+	return func(o *options) {
+		o.globalStatus = status
+	}
 }
 
-func RateLimiter(rl Limiter) func(http.Handler) http.Handler {
+func RateLimiter(rl Limiter, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-
-				ip = r.RemoteAddr
+			target, key, req := rl, o.key, r
+			if o.routes != nil {
+				if route, matched := o.routes.Match(r); route != nil {
+					target, key, req = route.Limiter, route.Key, matched
+				}
 			}
 
-			token := r.Header.Get("API_KEY")
+			id, err := o.identify(req, key)
+			if err != nil {
+				http.Error(w, invalidTokenMessage, http.StatusUnauthorized)
+				return
+			}
 
-			allowed, err := rl.Allow(r.Context(), ip, token)
+			decision, err := target.CheckIdentity(r.Context(), id)
 			if err != nil {
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 				return
 			}
 
-			if !allowed {
-				http.Error(w, "you have reached the maximum number of requests or actions allowed within a certain time frame", http.StatusTooManyRequests)
+			writeHeaders(w.Header(), o.headerStyle, decision, time.Now())
+
+			if !decision.Allowed {
+				if decision.Rule == limiter.RuleGlobal {
+					http.Error(w, globalLimitMessage, o.globalStatus)
+					return
+				}
+				http.Error(w, limitExceededMessage, http.StatusTooManyRequests)
+				return
+			}
+
+			if decision.Delay > 0 && !wait(r.Context(), decision.Delay) {
+				// The client is gone, so give its queue slot to the next request.
+				if refunder, ok := target.(Refunder); ok {
+					if err := refunder.Refund(context.WithoutCancel(r.Context()), id); err != nil {
+						log.Printf("failed to refund delayed request: %v", err)
+					}
+				}
+				http.Error(w, limitExceededMessage, http.StatusTooManyRequests)
 				return
 			}
 
@@ -36,3 +131,45 @@ func RateLimiter(rl Limiter) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// wait holds the request for its delay, returning false if the request context ends first.
+func wait(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// identify returns the identity the request is limited for; a nil key limits by IP only.
+func (o options) identify(r *http.Request, key KeyExtractor) (limiter.Identity, error) {
+	ip := remoteIP(r)
+	if o.clientIP != nil {
+		ip = o.clientIP.ClientIP(r)
+	}
+
+	if key == nil {
+		return limiter.Identity{IP: ip}, nil
+	}
+
+	id := limiter.Identity{IP: ip}
+	if extractor, ok := key.(IdentityExtractor); ok {
+		var err error
+		id, err = extractor.ExtractIdentity(r)
+		if err != nil {
+			return limiter.Identity{}, err
+		}
+		id.IP = ip
+	} else {
+		id.Token = key.ExtractKey(r)
+	}
+
+	if o.tokenHasher != nil && id.Token != "" {
+		id.Token = o.tokenHasher(id.Token)
+	}
+	return id, nil
+}
@@ -376,3 +376,172 @@ func TestRateLimiter_Middleware_IPv6(t *testing.T) {
 		t.Errorf("expected status 200, got %d", rec.Code)
 	}
 }
+
+func TestRateLimiter_Middleware_DelaysShapedRequest(t *testing.T) {
+	handlerCalled := false
+	handler := RateLimiter(&stubLimiter{decision: limiter.Decision{
+		Allowed: true,
+		Limit:   10,
+		Delay:   50 * time.Millisecond,
+	}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+	if !handlerCalled {
+		t.Error("expected handler to be called after the delay")
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("expected request to be held for 50ms, got %v", elapsed)
+	}
+}
+
+type refundingLimiter struct {
+	stubLimiter
+	refunded []limiter.Identity
+	ctxErr   error
+}
+
+func (l *refundingLimiter) Refund(ctx context.Context, id limiter.Identity) error {
+	l.refunded = append(l.refunded, id)
+	l.ctxErr = ctx.Err()
+	return nil
+}
+
+func TestRateLimiter_Middleware_DelayAbortedByContext(t *testing.T) {
+	handlerCalled := false
+	rl := &refundingLimiter{stubLimiter: stubLimiter{decision: limiter.Decision{
+		Allowed: true,
+		Limit:   10,
+		Delay:   time.Minute,
+	}}}
+	handler := RateLimiter(rl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", rec.Code)
+	}
+	if handlerCalled {
+		t.Error("expected handler not to be called when the context ends while waiting")
+	}
+	if len(rl.refunded) != 1 || rl.refunded[0] != rl.id {
+		t.Errorf("expected the delayed request to be refunded once, got %+v", rl.refunded)
+	}
+	if rl.ctxErr != nil {
+		t.Errorf("expected the refund not to use the ended request context, got %v", rl.ctxErr)
+	}
+}
+
+func TestRateLimiter_Middleware_GlobalLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		decision   limiter.Decision
+		opts       []Option
+		wantStatus int
+	}{
+		{
+			name:       "allowed",
+			decision:   limiter.Decision{Allowed: true, Limit: 10},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "global limit exceeded",
+			decision:   limiter.Decision{Limit: 1000, RetryAfter: time.Second, Rule: limiter.RuleGlobal},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "global limit exceeded with custom status",
+			decision:   limiter.Decision{Limit: 1000, RetryAfter: time.Second, Rule: limiter.RuleGlobal},
+			opts:       []Option{WithGlobalStatus(http.StatusTooManyRequests)},
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "client limit exceeded",
+			decision:   limiter.Decision{Limit: 10, RetryAfter: time.Second, Rule: limiter.RuleToken},
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RateLimiter(&stubLimiter{decision: tt.decision}, tt.opts...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			body, _ := io.ReadAll(rec.Body)
+			if isGlobal := string(body) == globalLimitMessage+"\n"; isGlobal != (tt.decision.Rule == limiter.RuleGlobal) {
+				t.Errorf("unexpected body %q", body)
+			}
+			if tt.decision.Rule == limiter.RuleGlobal {
+				if got := rec.Header().Get("X-RateLimit-Limit"); got != "1000" {
+					t.Errorf("expected headers of the global limit, got limit %q", got)
+				}
+				if got := rec.Header().Get("Retry-After"); got != "1" {
+					t.Errorf("expected Retry-After 1, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Middleware_GlobalRejectionKeepsClientBudget(t *testing.T) {
+	store := limiter.NewMemoryStore(0, 0)
+	global := limiter.NewGlobalLimiter(1, nil, limiter.WithGlobalWindow(time.Hour))
+	withGlobal := limiter.NewRateLimiter(store, 10, time.Minute, nil, limiter.WithIPWindow(time.Hour), limiter.WithGlobalLimiter(global))
+	clientOnly := limiter.NewRateLimiter(store, 10, time.Minute, nil, limiter.WithIPWindow(time.Hour))
+
+	serve := func(rl Limiter) *httptest.ResponseRecorder {
+		t.Helper()
+		handler := RateLimiter(rl)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(withGlobal); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "9" {
+		t.Fatalf("expected the first request to be served with 9 remaining, got %d %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	for i := 0; i < 3; i++ {
+		if rec := serve(withGlobal); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("request %d: expected the global limit to reject, got %d", i+2, rec.Code)
+		}
+	}
+
+	// The client limit only counted the served request.
+	if rec := serve(clientOnly); rec.Header().Get("X-RateLimit-Remaining") != "8" {
+		t.Errorf("expected 8 requests remaining after the global rejections, got %q", rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
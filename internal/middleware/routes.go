@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Precedence decides which route rule applies when several match.
+type Precedence string
+
+const (
+	// PrecedenceSpecific picks the most specific pattern, like http.ServeMux.
+	PrecedenceSpecific Precedence = "specific"
+	// PrecedenceFirst picks the first matching rule in declaration order.
+	PrecedenceFirst Precedence = "first"
+)
+
+func ParsePrecedence(s string) (Precedence, error) {
+	switch precedence := Precedence(s); precedence {
+	case PrecedenceSpecific, PrecedenceFirst:
+		return precedence, nil
+	default:
+		return "", fmt.Errorf("unknown route precedence: %s", s)
+	}
+}
+
+// Route is a rate limit rule for the requests matching an http.ServeMux pattern.
+type Route struct {
+	ID      string
+	Pattern string
+	Limiter Limiter
+	// Key identifies the client for this route; nil limits by IP only.
+	Key KeyExtractor
+}
+
+// RouteTable finds the route rule of a request using http.ServeMux matching.
+type RouteTable struct {
+	muxes []*http.ServeMux
+}
+
+type routeMatchKey struct{}
+
+type routeMatch struct {
+	route *Route
+	req   *http.Request
+}
+
+func NewRouteTable(routes []Route, precedence Precedence) (*RouteTable, error) {
+	t := &RouteTable{}
+	ids := make(map[string]bool, len(routes))
+
+	mux := http.NewServeMux()
+	for i := range routes {
+		route := &routes[i]
+		if route.ID == "" {
+			return nil, fmt.Errorf("route %d: missing id", i)
+		}
+		if ids[route.ID] {
+			return nil, fmt.Errorf("route %s: duplicate id", route.ID)
+		}
+		ids[route.ID] = true
+
+		if precedence == PrecedenceFirst {
+			mux = http.NewServeMux()
+			t.muxes = append(t.muxes, mux)
+		}
+		if err := handlePattern(mux, route); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.ID, err)
+		}
+	}
+
+	if precedence != PrecedenceFirst {
+		t.muxes = []*http.ServeMux{mux}
+	}
+	return t, nil
+}
+
+// handlePattern registers a handler recording the matched route, turning panics into errors.
+func handlePattern(mux *http.ServeMux, route *Route) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+
+	if route.Pattern == "" {
+		return errors.New("missing pattern")
+	}
+
+	mux.HandleFunc(route.Pattern, func(w http.ResponseWriter, r *http.Request) {
+		if m, ok := r.Context().Value(routeMatchKey{}).(*routeMatch); ok {
+			m.route, m.req = route, r
+		}
+	})
+	return nil
+}
+
+// Match returns the route rule for r, or nil, and r with the pattern's path values.
+func (t *RouteTable) Match(r *http.Request) (*Route, *http.Request) {
+	for _, mux := range t.muxes {
+		m := &routeMatch{}
+		mux.ServeHTTP(discardResponse{}, r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, m)))
+		if m.route != nil {
+			return m.route, m.req.WithContext(r.Context())
+		}
+	}
+	return nil, r
+}
+
+// discardResponse absorbs what http.ServeMux writes for requests matching no pattern.
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponse) WriteHeader(int)             {}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+func matchedRoute(t *testing.T, table *RouteTable, method, target string) string {
+	t.Helper()
+
+	route, _ := table.Match(httptest.NewRequest(method, target, nil))
+	if route == nil {
+		return ""
+	}
+	return route.ID
+}
+
+func TestRouteTable_Precedence(t *testing.T) {
+	routes := []Route{
+		{ID: "api", Pattern: "/api/"},
+		{ID: "login", Pattern: "POST /api/login"},
+		{ID: "orders", Pattern: "GET /api/tenants/{tenant}/orders"},
+		{ID: "exact-search", Pattern: "GET /search/{$}"},
+	}
+
+	tests := []struct {
+		method, target  string
+		specific, first string
+	}{
+		{http.MethodPost, "/api/login", "login", "api"},
+		{http.MethodGet, "/api/login", "api", "api"},
+		{http.MethodGet, "/api/tenants/acme/orders", "orders", "api"},
+		{http.MethodHead, "/api/tenants/acme/orders", "orders", "api"},
+		{http.MethodGet, "/search/", "exact-search", "exact-search"},
+		{http.MethodGet, "/search/more", "", ""},
+		{http.MethodGet, "/", "", ""},
+	}
+
+	specific, err := NewRouteTable(routes, PrecedenceSpecific)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first, err := NewRouteTable(routes, PrecedenceFirst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range tests {
+		if got := matchedRoute(t, specific, tt.method, tt.target); got != tt.specific {
+			t.Errorf("specific %s %s: expected route %q, got %q", tt.method, tt.target, tt.specific, got)
+		}
+		if got := matchedRoute(t, first, tt.method, tt.target); got != tt.first {
+			t.Errorf("first %s %s: expected route %q, got %q", tt.method, tt.target, tt.first, got)
+		}
+	}
+}
+
+func TestRouteTable_MatchSetsPathValues(t *testing.T) {
+	table, err := NewRouteTable([]Route{{ID: "orders", Pattern: "GET /tenants/{tenant}/orders"}}, PrecedenceSpecific)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route, req := table.Match(httptest.NewRequest(http.MethodGet, "/tenants/acme/orders", nil))
+	if route == nil {
+		t.Fatal("expected route to match")
+	}
+	if got := req.PathValue("tenant"); got != "acme" {
+		t.Errorf("expected path value acme, got %q", got)
+	}
+}
+
+func TestNewRouteTable_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		routes     []Route
+		precedence Precedence
+	}{
+		{"missing id", []Route{{Pattern: "/login"}}, PrecedenceSpecific},
+		{"duplicate id", []Route{{ID: "a", Pattern: "/a"}, {ID: "a", Pattern: "/b"}}, PrecedenceFirst},
+		{"missing pattern", []Route{{ID: "a"}}, PrecedenceSpecific},
+		{"invalid pattern", []Route{{ID: "a", Pattern: "GET /{bad"}}, PrecedenceFirst},
+		{"conflicting patterns", []Route{{ID: "a", Pattern: "/{x}/b"}, {ID: "b", Pattern: "/a/{y}"}}, PrecedenceSpecific},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRouteTable(tt.routes, tt.precedence); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := NewRouteTable([]Route{{ID: "a", Pattern: "/{x}/b"}, {ID: "b", Pattern: "/a/{y}"}}, PrecedenceFirst); err != nil {
+		t.Errorf("expected overlapping patterns to be allowed with first match, got %v", err)
+	}
+}
+
+func TestRateLimiter_Routes(t *testing.T) {
+	defaults := &stubLimiter{decision: limiter.Decision{Allowed: true}}
+	login := &stubLimiter{decision: limiter.Decision{Allowed: false}}
+	orders := &stubLimiter{decision: limiter.Decision{Allowed: true}}
+
+	table, err := NewRouteTable([]Route{
+		{ID: "login", Pattern: "POST /login", Limiter: login},
+		{ID: "orders", Pattern: "/tenants/{tenant}/orders", Limiter: orders, Key: PathKey("tenant")},
+	}, PrecedenceSpecific)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := RateLimiter(defaults, WithRoutes(table))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, target string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("API_KEY", "abc123")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(http.MethodPost, "/login"); code != http.StatusTooManyRequests {
+		t.Errorf("expected login rule to reject, got %d", code)
+	}
+	if login.id.Token != "" {
+		t.Errorf("expected route without key to limit by ip, got token %q", login.id.Token)
+	}
+
+	if code := serve(http.MethodGet, "/login"); code != http.StatusOK {
+		t.Errorf("expected GET /login to use the default limiter, got %d", code)
+	}
+	if defaults.id.Token != "abc123" {
+		t.Errorf("expected default key extractor, got token %q", defaults.id.Token)
+	}
+
+	serve(http.MethodGet, "/tenants/acme/orders")
+	if orders.id.Token != "acme" {
+		t.Errorf("expected orders rule to be keyed by tenant, got %q", orders.id.Token)
+	}
+}
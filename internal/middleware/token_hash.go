@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	TokenHashNone   = "none"
+	TokenHashSHA256 = "sha256"
+	TokenHashHMAC   = "hmac-sha256"
+)
+
+// TokenHasher returns the digest a client token is known by.
+type TokenHasher func(token string) string
+
+// SHA256Token returns the hex-encoded SHA-256 digest of token.
+func SHA256Token(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HMACToken returns a hasher computing the hex-encoded HMAC-SHA256 of tokens.
+func HMACToken(secret []byte) TokenHasher {
+	return func(token string) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(token))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+}
+
+// ParseTokenHasher returns the hasher for none, sha256 or hmac-sha256.
+func ParseTokenHasher(algorithm string, secret []byte) (TokenHasher, error) {
+	switch algorithm {
+	case TokenHashNone:
+		return nil, nil
+	case TokenHashSHA256:
+		return SHA256Token, nil
+	case TokenHashHMAC:
+		if len(secret) == 0 {
+			return nil, errors.New("hmac-sha256 requires a secret")
+		}
+		return HMACToken(secret), nil
+	default:
+		return nil, fmt.Errorf("unknown token hash %q (expected %s, %s or %s)", algorithm, TokenHashNone, TokenHashSHA256, TokenHashHMAC)
+	}
+}
+
+// IsTokenDigest reports whether s is 64 lowercase hex digits.
+func IsTokenDigest(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// WithTokenHasher identifies clients by the digest of their token.
+func WithTokenHasher(hasher TokenHasher) Option {
+	return func(o *options) {
+		o.tokenHasher = hasher
+	}
+}
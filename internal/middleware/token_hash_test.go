@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carlosfiori/pos-go-fullcycle-desafio-rate-limit/internal/limiter"
+)
+
+func TestSHA256Token(t *testing.T) {
+	if digest := SHA256Token("abc123"); digest != "6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a84118090" {
+		t.Errorf("unexpected digest %s", digest)
+	}
+}
+
+func TestHMACToken(t *testing.T) {
+	hasher := HMACToken([]byte("key"))
+	if digest := hasher("The quick brown fox jumps over the lazy dog"); digest != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Errorf("unexpected digest %s", digest)
+	}
+	if HMACToken([]byte("other"))("abc123") == hasher("abc123") {
+		t.Error("expected digests to depend on the secret")
+	}
+}
+
+func TestParseTokenHasher(t *testing.T) {
+	if hasher, err := ParseTokenHasher(TokenHashNone, nil); hasher != nil || err != nil {
+		t.Errorf("expected no hasher for none, got %v", err)
+	}
+	if hasher, err := ParseTokenHasher(TokenHashSHA256, nil); err != nil || hasher("abc123") != SHA256Token("abc123") {
+		t.Errorf("expected the SHA-256 hasher, got %v", err)
+	}
+	if hasher, err := ParseTokenHasher(TokenHashHMAC, []byte("key")); err != nil || hasher("abc123") != HMACToken([]byte("key"))("abc123") {
+		t.Errorf("expected the HMAC hasher, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		algorithm string
+		secret    string
+	}{
+		{TokenHashHMAC, ""},
+		{"md5", ""},
+		{"", ""},
+	} {
+		if _, err := ParseTokenHasher(tt.algorithm, []byte(tt.secret)); err == nil {
+			t.Errorf("expected error for %q with secret %q", tt.algorithm, tt.secret)
+		}
+	}
+}
+
+func TestIsTokenDigest(t *testing.T) {
+	tests := map[string]bool{
+		SHA256Token("abc123"): true,
+		"abc123":              false,
+		"6CA13D52CA70C883E0F0BB101E425A89E8624DE51DB2D2392593AF6A84118090": false,
+		"6ca13d52ca70c883e0f0bb101e425a89e8624de51db2d2392593af6a8411809z": false,
+	}
+	for s, want := range tests {
+		if got := IsTokenDigest(s); got != want {
+			t.Errorf("IsTokenDigest(%q) = %v, expected %v", s, got, want)
+		}
+	}
+}
+
+func TestRateLimiter_Middleware_TokenHasher(t *testing.T) {
+	var capturedToken string
+	wrapper := &testLimiterWrapper{
+		store:     &mockStore{allowed: true},
+		tokenHook: func(token string) { capturedToken = token },
+	}
+	digest := SHA256Token("test-token")
+	rl := limiter.NewRateLimiter(wrapper, 10, 5*time.Minute, map[string]limiter.TokenConfig{
+		digest: {Limit: 100, BlockDuration: 10 * time.Minute},
+	})
+
+	handler := RateLimiter(rl, WithTokenHasher(SHA256Token))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("API_KEY", "test-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if capturedToken != digest {
+		t.Errorf("expected the token to be counted by digest %s, got %q", digest, capturedToken)
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "100" {
+		t.Errorf("expected the config of the digest to apply, got limit %s", rec.Header().Get("X-RateLimit-Limit"))
+	}
+}